@@ -1,14 +1,20 @@
 package main
 
 import (
+	"fmt"
 	"github.com/rivo/tview"
-	"path/filepath"
+	"runtime/debug"
+	"sync"
 )
 
 type App struct {
 	UI         *tview.Application
 	State      AppState
 	Primitives AppPrimitives
+
+	// Panic of a background goroutine which stopped the UI
+	failureMutex sync.Mutex
+	failure      error
 }
 
 type AppState struct {
@@ -19,11 +25,16 @@ type AppState struct {
 
 type AppTempState struct {
 	Keyword string
+	// Cluster of the last cut into a named cluster, it's suggested for the next cut
+	LastCluster string
 
 	CachedClusters map[string]*Cluster
 
 	RootNode     *ClusterNode
 	SelectedNode *ClusterNode
+
+	// Background computation of the tree of the typed query
+	Search liveSearch
 }
 
 type AppPrimitives struct {
@@ -38,45 +49,266 @@ type AppPrimitives struct {
 	StatusBar *tview.TextView
 }
 
-func (app *App) SearchKeyword(keyword string) {
-	var root *Cluster
-	var node *ClusterNode
+// SearchKeyword makes the query the root of the cluster tree.
+// The tree is kept if the query has a syntax error.
+// If the tree of the same query is regenerated, its expanded nodes and selection are kept.
+func (app *App) SearchKeyword(keyword string) error {
+	var expanded map[string]struct{}
+	var selected string
+	if app.State.Temp.RootNode != nil && app.State.Temp.Keyword == keyword {
+		expanded = expandedNodes(app.State.Temp.RootNode)
+		selected = app.State.Temp.SelectedNode.GetFullName()
+	}
+
+	app.CancelSearch()
+	root, err := app.State.Project.RootCluster(keyword)
+	if err != nil {
+		return err
+	}
+	config := app.State.Project.Config
+	node := newRootNode(keyword, root, config.MinClusterSize, config.ClusterSort)
+	app.restoreExpansion(node, expanded)
+
+	current := node
+	if selected != "" {
+		node.Walk(func(n, parent *ClusterNode) bool {
+			if n.GetFullName() == selected {
+				current = n
+			}
+			return n.IsExpanded
+		})
+	}
+
+	app.setRootNode(keyword, node, current)
+	return nil
+}
+
+// newRootNode returns the expanded root node of the query with its children.
+// It doesn't use the app state, so the tree can be computed in the background.
+func newRootNode(keyword string, root *Cluster, minSize uint, order string) *ClusterNode {
+	node := NewClusterNode(keyword, root, true, nil)
 	if keyword == "" {
-		root = NewCluster(keyword, app.State.Project.Rows, nil)
-		node = NewClusterNode("Слова", root, true, nil)
-	} else {
-		cut := filterRows(keyword, app.State.Project.Rows)
-		root = NewCluster(keyword, cut, nil)
-		node = NewClusterNode(keyword, root, true, nil)
-	}
-	children := node.GenerateChildren(app.State.Temp.CachedClusters)
-	sortClusterNodes(children)
+		node.Name = "Слова"
+	}
+	children := node.GenerateChildren(nil, minSize)
+	sortClusterNodes(children, order)
 	node.SetChildren(children)
+	return node
+}
 
+// setRootNode shows the tree of the query with the current node
+func (app *App) setRootNode(keyword string, node, current *ClusterNode) {
 	app.State.Temp.CachedClusters = nil
-	app.State.Temp.SelectedNode = node
+	app.State.Temp.SelectedNode = current
 	app.State.Temp.RootNode = node
 	app.State.Temp.Keyword = keyword
 	app.Primitives.ClusterTree.SetRoot(node)
-	app.Primitives.ClusterTree.SetCurrentNode(node)
-}
-
-func (app *App) ProcessOperation(keyword string, rows []*Row, operation int) {
-	var path string
-	if operation != OperationSilentRemove {
-		if operation == OperationRemove {
-			path = filepath.Join(app.State.Project.Paths.RemovedDir, keyword+".csv")
-		} else if operation == OperationAdd {
-			path = filepath.Join(app.State.Project.Paths.ClustersDir, keyword+".csv")
-		} else {
-			panic("passed operation is unknown")
+	app.Primitives.ClusterTree.SetCurrentNode(current)
+}
+
+// GenerateChildren sets the node children according to the tree settings of the project
+func (app *App) GenerateChildren(node *ClusterNode) []*ClusterNode {
+	config := app.State.Project.Config
+	children := node.GenerateChildren(app.State.Temp.CachedClusters, config.MinClusterSize)
+	sortClusterNodes(children, config.ClusterSort)
+	node.SetChildren(children)
+	return children
+}
+
+// restoreExpansion expands the children which full names are in the set
+func (app *App) restoreExpansion(node *ClusterNode, expanded map[string]struct{}) {
+	for _, child := range node.children {
+		if _, ok := expanded[child.GetFullName()]; !ok {
+			continue
 		}
+		app.GenerateChildren(child)
+		child.Expand()
+		app.restoreExpansion(child, expanded)
+	}
+}
 
-		go SaveRows(rows, path)
+// expandedNodes returns full names of the expanded nodes of the tree
+func expandedNodes(root *ClusterNode) map[string]struct{} {
+	expanded := make(map[string]struct{})
+	root.Walk(func(node, parent *ClusterNode) bool {
+		if node.IsExpanded {
+			expanded[node.GetFullName()] = struct{}{}
+		}
+		return node.IsExpanded
+	})
+	return expanded
+}
+
+// RegenerateTree rebuilds the tree of the current query after the tree settings are changed
+func (app *App) RegenerateTree() {
+	app.State.Project.SaveConfig()
+	app.SearchKeyword(app.State.Temp.Keyword)
+	app.UpdateView()
+}
+
+// NodeRows returns the rows which are cut by the node. Synonyms are merged in the tree,
+// so the rows are selected again if cuts don't cover the synonyms.
+func (app *App) NodeRows(node *ClusterNode) ([]*Row, error) {
+	project := &app.State.Project
+	if len(project.Config.Synonyms) == 0 || project.expandSynonyms() {
+		return node.Rows, nil
+	}
+	return project.Select(node.GetFullName())
+}
+
+func (app *App) ProcessOperation(keyword string, rows []*Row, operation int) error {
+	if _, err := app.State.Project.ProcessOperation(keyword, rows, operation); err != nil {
+		return err
 	}
+	app.SearchKeyword(app.State.Temp.Keyword)
+	app.State.Project.Save()
+	return nil
+}
 
-	app.State.Project.RemoveRows(rows)
-	app.State.Project.History.AddOperation(keyword, operation)
+// ProcessRowsOperation cuts the rows of the keyword list by a row operation keeping the tree expansion and selection
+func (app *App) ProcessRowsOperation(name string, rows []*Row, operation int) error {
+	if _, err := app.State.Project.ProcessRowsOperation(name, rows, operation); err != nil {
+		return err
+	}
 	app.SearchKeyword(app.State.Temp.Keyword)
-	go app.State.Project.Save()
+	app.State.Project.Save()
+	return nil
+}
+
+// ProcessOperationInto cuts the rows of the query into the named cluster keeping the tree expansion and selection
+func (app *App) ProcessOperationInto(keyword, cluster string, rows []*Row, operation int) error {
+	if _, err := app.State.Project.ProcessOperationInto(keyword, cluster, rows, operation); err != nil {
+		return err
+	}
+	app.SearchKeyword(app.State.Temp.Keyword)
+	app.State.Project.Save()
+	return nil
+}
+
+// RestoreRows returns the rows of the saved cluster to the remaining ones keeping the tree expansion and selection
+func (app *App) RestoreRows(cluster *SavedCluster, rows []*Row) {
+	app.State.Project.RestoreRows(cluster, rows)
+	app.SearchKeyword(app.State.Temp.Keyword)
+	app.State.Project.Save()
+}
+
+// MergeClusters merges the saved cluster into the target one. The merge is an operation of the history,
+// the remaining rows are not changed, so the tree is kept.
+func (app *App) MergeClusters(cluster, target *SavedCluster) error {
+	if _, err := app.State.Project.MergeClusters(cluster, target); err != nil {
+		return err
+	}
+	app.State.Project.Save()
+	return nil
+}
+
+// Undo reverts the last applied operation keeping the tree expansion and selection
+func (app *App) Undo() {
+	op := app.State.Project.Undo()
+	if op == nil {
+		app.SetStatusBarText("Нечего отменять")
+		return
+	}
+	app.SearchKeyword(app.State.Temp.Keyword)
+	app.UpdateView()
+	app.State.Project.Save()
+	app.SetStatusBarText(fmt.Sprintf("Отменено: %s[white], вернулось запросов: %d", operationText(op), len(op.rows)))
+}
+
+// Redo applies the next operation of the history keeping the tree expansion and selection
+func (app *App) Redo() {
+	op, err := app.State.Project.Redo()
+	if err != nil {
+		app.SetStatusBarText("[red]Не удалось повторить операцию: " + tview.Escape(err.Error()))
+		return
+	}
+	if op == nil {
+		app.SetStatusBarText("Нечего повторять")
+		return
+	}
+	app.SearchKeyword(app.State.Temp.Keyword)
+	app.UpdateView()
+	app.State.Project.Save()
+	app.SetStatusBarText(fmt.Sprintf("Повторено: %s[white], вырезано запросов: %d", operationText(op), len(op.rows)))
+}
+
+// operationText colors the operation as the history page does.
+// Brackets of the query syntax are escaped, so they are not taken for color tags.
+func operationText(op *KeywordOperation) string {
+	keyword := tview.Escape(operationTarget(op))
+	if op.Merge != "" {
+		return "[yellow]» " + keyword
+	}
+	switch op.Operation {
+	case OperationAdd:
+		return "[green]+ " + keyword
+	case OperationSilentRemove:
+		return "[red]-- " + keyword
+	case OperationRestore:
+		return "[yellow]< " + keyword
+	}
+	return "[red]- " + keyword
+}
+
+// SwitchBranch makes the history branch active keeping the tree expansion and selection
+func (app *App) SwitchBranch(branch *HistoryBranch) {
+	err := app.State.Project.SwitchBranch(branch)
+	app.SearchKeyword(app.State.Temp.Keyword)
+	app.UpdateView()
+	app.State.Project.Save()
+	if err != nil {
+		app.SetStatusBarText("[red]Ветка применена не полностью: " + tview.Escape(err.Error()))
+		return
+	}
+	app.SetStatusBarText("Активная ветка истории: " + tview.Escape(branch.Name))
+}
+
+// SetOperation restores the state of the history after the operation keeping the tree expansion and selection
+func (app *App) SetOperation(operation *KeywordOperation) {
+	err := app.State.Project.SetOperation(operation)
+	app.SearchKeyword(app.State.Temp.Keyword)
+	app.UpdateView()
+	app.State.Project.Save()
+	if err != nil {
+		app.SetStatusBarText("[red]История применена не полностью: " + tview.Escape(err.Error()))
+	}
+}
+
+// Run shows the UI until it's stopped. A panic in the UI or in its background goroutines
+// is returned as an error after the terminal is restored by tview and the project is saved.
+func (app *App) Run(root tview.Primitive) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			app.State.Project.Save()
+			err = fmt.Errorf("unexpected error: %v, the project is saved\n%s", r, debug.Stack())
+		}
+	}()
+	if err := app.UI.SetRoot(root, true).SetFocus(root).Run(); err != nil {
+		return err
+	}
+	app.failureMutex.Lock()
+	defer app.failureMutex.Unlock()
+	if app.failure != nil {
+		app.State.Project.Save()
+		return app.failure
+	}
+	return nil
+}
+
+// Fail stops the UI because of a panic of a background goroutine, Run saves the project and returns the error.
+// It's called from the goroutine which recovered the panic.
+func (app *App) Fail(value interface{}, stack []byte) {
+	app.failureMutex.Lock()
+	if app.failure == nil {
+		app.failure = fmt.Errorf("unexpected error: %v, the project is saved\n%s", value, stack)
+	}
+	app.failureMutex.Unlock()
+	app.UI.Stop()
+}
+
+// recoverBackground is deferred by the background goroutines of the UI
+func (app *App) recoverBackground() {
+	if r := recover(); r != nil {
+		app.Fail(r, debug.Stack())
+	}
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"github.com/rivo/tview"
 	"sort"
 	"strings"
 )
@@ -25,6 +26,10 @@ func (app *App) UpdateStatusBar() {
 
 	clusterInfo := fmt.Sprintf("Всего запросов: %v | В корневом: %v | В текущем: %v", len(app.State.Project.Rows), len(app.State.Temp.RootNode.Rows), len(app.State.Temp.SelectedNode.Rows))
 
+	if category := app.State.Project.Category; category != "" {
+		clusterInfo += " | Категория: " + tview.Escape(category)
+	}
+
 	fmt.Fprintf(statusBar, clusterInfo)
 }
 
@@ -41,7 +46,7 @@ func (app *App) UpdateKeywordList(){
 	sortRowsByStrongVolume(app.State.Temp.SelectedNode.Rows)
 	var keywords []KeywordListItem
 	for _, row := range app.State.Temp.SelectedNode.Rows {
-		keyword := KeywordListItem{ Text:row.Keyword, Volume:row.Frequency, StrongVolume: row.StrongFrequency }
+		keyword := KeywordListItem{ Text:row.Keyword, Volume:row.Frequency, StrongVolume: row.StrongFrequency, Row: row }
 		keywords = append(keywords, keyword)
 	}
 	list.SetKeywords(keywords)
@@ -64,9 +69,7 @@ func (app *App) ExpandAndSelect(clusterName string) {
 	closest.Expand()
 	var nodes = root.children
 	if len(closest.children) == 0 {
-		nodes = root.GenerateChildren(app.State.Temp.CachedClusters)
-		sortClusterNodes(nodes)
-		root.SetChildren(nodes)
+		nodes = app.GenerateChildren(root)
 	}
 	for len(nodes) > 0 {
 		node := nodes[0]
@@ -82,9 +85,7 @@ func (app *App) ExpandAndSelect(clusterName string) {
 		} else if nodeSimilarity > closestSimilarity {
 			closestSimilarity = nodeSimilarity
 			closest = node
-			nodes = closest.GenerateChildren(app.State.Temp.CachedClusters)
-			sortClusterNodes(nodes)
-			closest.SetChildren(nodes)
+			nodes = app.GenerateChildren(closest)
 			closest.Expand()
 		}
 	}
@@ -104,12 +105,43 @@ func orderSimilarity(a, b[]string) int {
 }
 
 
-func sortClusterNodes(nodes []*ClusterNode) {
+// Parameter order is one of clusterSorts, nodes are sorted by keyword count by default
+func sortClusterNodes(nodes []*ClusterNode, order string) {
 	sort.Slice(nodes, func(i, j int) bool {
-		return len(nodes[i].Rows) > len(nodes[j].Rows)
+		a, b := nodes[i], nodes[j]
+		switch order {
+		case ClusterSortName:
+			return a.Name < b.Name
+		case ClusterSortFrequency, ClusterSortExact:
+			aStats, bStats := a.Stats(), b.Stats()
+			aFreq, bFreq := aStats.Frequency, bStats.Frequency
+			if order == ClusterSortExact {
+				aFreq, bFreq = aStats.StrongFrequency, bStats.StrongFrequency
+			}
+			if aFreq != bFreq {
+				return aFreq > bFreq
+			}
+		}
+		if len(a.Rows) != len(b.Rows) {
+			return len(a.Rows) > len(b.Rows)
+		}
+		return a.Name < b.Name
 	})
 }
 
+// clusterSortName returns the name of the order for the status bar
+func clusterSortName(order string) string {
+	switch order {
+	case ClusterSortFrequency:
+		return "по широкой частотности"
+	case ClusterSortExact:
+		return "по строгой частотности"
+	case ClusterSortName:
+		return "по алфавиту"
+	}
+	return "по количеству запросов"
+}
+
 func sortRowsByVolume(rows []*Row) {
 	sort.Slice(rows, func(i, j int) bool {
 		return rows[i].Frequency > rows[j].Frequency
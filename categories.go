@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Category is a section of the output structure. Clusters of a category are written into its
+// directory inside the clusters directory, so nested categories make a directory tree.
+type Category struct {
+	Name     string      `json:"name"`
+	Children []*Category `json:"children,omitempty"`
+}
+
+// Separator of the names in the path of a category
+const categorySeparator = "/"
+
+// LoadCategories reads the category tree, a missing file means no categories
+func LoadCategories(path string) ([]*Category, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var categories []*Category
+	if err := json.Unmarshal(data, &categories); err != nil {
+		return nil, jsonFileError(path, 0, data, err)
+	}
+	if err := validateCategories(categories, ""); err != nil {
+		return nil, &FileError{File: path, Err: err}
+	}
+	return categories, nil
+}
+
+func validateCategories(categories []*Category, parent string) error {
+	for i, category := range categories {
+		if err := validateCategoryName(categories[:i], parent, category.Name); err != nil {
+			return err
+		}
+		if err := validateCategories(category.Children, joinCategoryPath(parent, category.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateCategoryName checks the name of a category of the parent which has the siblings.
+// The names are directories, so siblings can't have the same directory.
+func validateCategoryName(siblings []*Category, parent, name string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("category name is empty")
+	}
+	if strings.Contains(name, categorySeparator) {
+		return fmt.Errorf("category name \"%s\" contains %s", name, categorySeparator)
+	}
+	dir := clusterFileName(name)
+	if dir == "." || dir == ".." {
+		return fmt.Errorf("category name \"%s\" is not allowed", name)
+	}
+	if parent == "" && dir == ProjectRemovedDir {
+		return fmt.Errorf("category name \"%s\" is reserved for removed clusters", name)
+	}
+	for _, sibling := range siblings {
+		if clusterFileName(sibling.Name) == dir {
+			return fmt.Errorf("category \"%s\" already exists", joinCategoryPath(parent, name))
+		}
+	}
+	return nil
+}
+
+// joinCategoryPath returns the path of the category with the name inside the parent
+func joinCategoryPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + categorySeparator + name
+}
+
+// splitCategoryPath returns the path of the parent and the name of the category
+func splitCategoryPath(path string) (string, string) {
+	if i := strings.LastIndex(path, categorySeparator); i >= 0 {
+		return path[:i], path[i+len(categorySeparator):]
+	}
+	return "", path
+}
+
+// isCategoryInside checks whether the category is the parent or inside it
+func isCategoryInside(category, parent string) bool {
+	return category == parent || strings.HasPrefix(category, parent+categorySeparator)
+}
+
+// findCategory returns the category with the name and its index in the list, nil if there is none
+func findCategory(categories []*Category, name string) (*Category, int) {
+	for i, category := range categories {
+		if category.Name == name {
+			return category, i
+		}
+	}
+	return nil, -1
+}
+
+// categoryList returns the children of the category by its path, the top level for an empty path
+func (p *Project) categoryList(path string) (*[]*Category, error) {
+	list := &p.Categories
+	if path == "" {
+		return list, nil
+	}
+	for _, name := range strings.Split(path, categorySeparator) {
+		category, _ := findCategory(*list, name)
+		if category == nil {
+			return nil, fmt.Errorf("category \"%s\" does not exist", path)
+		}
+		list = &category.Children
+	}
+	return list, nil
+}
+
+// CategoryExists checks the path of the category, the empty path is the root
+func (p *Project) CategoryExists(path string) bool {
+	_, err := p.categoryList(path)
+	return err == nil
+}
+
+// CategoryPaths returns the paths of all categories in the tree order
+func (p *Project) CategoryPaths() []string {
+	var paths []string
+	var walk func(categories []*Category, parent string)
+	walk = func(categories []*Category, parent string) {
+		for _, category := range categories {
+			path := joinCategoryPath(parent, category.Name)
+			paths = append(paths, path)
+			walk(category.Children, path)
+		}
+	}
+	walk(p.Categories, "")
+	return paths
+}
+
+// CategoryDir returns the directory of the category, the clusters directory for the root
+func (p *Project) CategoryDir(path string) string {
+	dir := p.Paths.ClustersDir
+	if path == "" {
+		return dir
+	}
+	for _, name := range strings.Split(path, categorySeparator) {
+		dir = filepath.Join(dir, clusterFileName(name))
+	}
+	return dir
+}
+
+// SaveCategories queues writing of the category tree and creates the directories of the categories
+func (p *Project) SaveCategories() {
+	data, err := json.MarshalIndent(p.Categories, "", "  ")
+	var dirs []string
+	for _, path := range p.CategoryPaths() {
+		dirs = append(dirs, p.CategoryDir(path))
+	}
+	p.write(func() error {
+		if err != nil {
+			return err
+		}
+		for _, dir := range dirs {
+			if err := os.MkdirAll(dir, 0777); err != nil {
+				return err
+			}
+		}
+		return writeFileDataAtomic(p.Paths.CategoriesFile, data)
+	})
+}
+
+// AddCategory adds the category with the name into the parent and returns its path
+func (p *Project) AddCategory(parent, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	list, err := p.categoryList(parent)
+	if err != nil {
+		return "", err
+	}
+	if err := validateCategoryName(*list, parent, name); err != nil {
+		return "", err
+	}
+	*list = append(*list, &Category{Name: name})
+	p.SaveCategories()
+	return joinCategoryPath(parent, name), nil
+}
+
+// RenameCategory renames the category and returns its new path
+func (p *Project) RenameCategory(path, name string) (string, error) {
+	parent, _ := splitCategoryPath(path)
+	return p.moveCategory(path, parent, strings.TrimSpace(name))
+}
+
+// MoveCategory moves the category with its subcategories and clusters into the parent
+// and returns its new path. The empty parent is the top level.
+func (p *Project) MoveCategory(path, parent string) (string, error) {
+	_, name := splitCategoryPath(path)
+	return p.moveCategory(path, parent, name)
+}
+
+func (p *Project) moveCategory(path, parent, name string) (string, error) {
+	oldParent, oldName := splitCategoryPath(path)
+	from, err := p.categoryList(oldParent)
+	if err != nil {
+		return "", err
+	}
+	category, index := findCategory(*from, oldName)
+	if category == nil {
+		return "", fmt.Errorf("category \"%s\" does not exist", path)
+	}
+	if isCategoryInside(parent, path) {
+		return "", fmt.Errorf("category \"%s\" can't be moved into itself", path)
+	}
+	to, err := p.categoryList(parent)
+	if err != nil {
+		return "", err
+	}
+	var siblings []*Category
+	for _, sibling := range *to {
+		if sibling != category {
+			siblings = append(siblings, sibling)
+		}
+	}
+	if err := validateCategoryName(siblings, parent, name); err != nil {
+		return "", err
+	}
+	newPath := joinCategoryPath(parent, name)
+	if newPath == path {
+		return path, nil
+	}
+	if err := p.checkCategoryTarget(path, newPath); err != nil {
+		return "", err
+	}
+
+	category.Name = name
+	if parent != oldParent {
+		*from = append((*from)[:index], (*from)[index+1:]...)
+		*to = append(*to, category)
+	}
+	p.renameCategoryPath(path, newPath)
+	p.SaveCategories()
+	return newPath, nil
+}
+
+// checkCategoryTarget checks that the directory of the new category path doesn't exist,
+// so the directory of the category is never renamed onto another one
+func (p *Project) checkCategoryTarget(from, to string) error {
+	// Queued writes can create or remove the directories
+	if err := p.flush(); err != nil {
+		return err
+	}
+	info, err := os.Stat(p.CategoryDir(to))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// A name which differs only in case is the same directory on some file systems
+	if old, err := os.Stat(p.CategoryDir(from)); err == nil && os.SameFile(old, info) {
+		return nil
+	}
+	return fmt.Errorf("directory %s already exists", p.relativePath(p.CategoryDir(to)))
+}
+
+// renameCategoryPath moves the clusters of the category and its subcategories into the new path:
+// the operations of all branches get the new category and file and the directory is renamed
+func (p *Project) renameCategoryPath(from, to string) {
+	renamed := func(category string) (string, bool) {
+		if !isCategoryInside(category, from) {
+			return category, false
+		}
+		return to + category[len(from):], true
+	}
+
+	// Branches share operations, so every operation is renamed once
+	moved := make(map[*KeywordOperation]bool)
+	relabeled := make(map[*KeywordOperation]bool)
+	for _, branch := range p.History.Branches {
+		for _, op := range branch.Operations {
+			// Restore and merge operations find their cluster by its label
+			if label := op.sourceLabel(); label != "" && !relabeled[op] {
+				relabeled[op] = true
+				if category, name := splitCategoryPath(label); category != ProjectRemovedDir {
+					if category, ok := renamed(category); ok {
+						op.setSourceLabel(joinCategoryPath(category, name))
+					}
+				}
+			}
+			if moved[op] || op.Operation != OperationAdd {
+				continue
+			}
+			if category, ok := renamed(op.Category); ok {
+				moved[op] = true
+				op.Category = category
+				op.File = p.relativePath(p.ClusterPath(op.Category, op.ClusterName(), op.Operation))
+			}
+		}
+	}
+	if category, ok := renamed(p.Category); ok {
+		p.Category = category
+	}
+
+	oldDir, newDir := p.CategoryDir(from), p.CategoryDir(to)
+	p.write(func() error {
+		if err := os.MkdirAll(filepath.Dir(newDir), 0777); err != nil {
+			return err
+		}
+		if err := os.Rename(oldDir, newDir); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+	p.SaveHistory()
+}
+
+// RemoveCategory removes the empty category. A category with subcategories
+// or with clusters of any history branch can't be removed.
+func (p *Project) RemoveCategory(path string) error {
+	parent, name := splitCategoryPath(path)
+	list, err := p.categoryList(parent)
+	if err != nil {
+		return err
+	}
+	category, index := findCategory(*list, name)
+	if category == nil {
+		return fmt.Errorf("category \"%s\" does not exist", path)
+	}
+	if len(category.Children) > 0 {
+		return fmt.Errorf("category \"%s\" has subcategories", path)
+	}
+	for _, branch := range p.History.Branches {
+		for _, op := range branch.Operations {
+			if op.Operation == OperationAdd && op.Category == path {
+				return fmt.Errorf("category \"%s\" has clusters in the history", path)
+			}
+		}
+	}
+
+	*list = append((*list)[:index], (*list)[index+1:]...)
+	if p.Category == path {
+		p.Category = parent
+	}
+	// The directory is kept if the user put other files into it
+	dir := p.CategoryDir(path)
+	p.write(func() error {
+		os.Remove(dir)
+		return nil
+	})
+	p.SaveCategories()
+	return nil
+}
+
+// MoveCluster moves the cluster of the operation into the category. All operations which
+// cut rows into the same cluster file are moved with it, so the cluster keeps all its rows.
+func (p *Project) MoveCluster(op *KeywordOperation, category string) error {
+	if op.Operation != OperationAdd {
+		return fmt.Errorf("only saved clusters have a category")
+	}
+	if !p.CategoryExists(category) {
+		return fmt.Errorf("category \"%s\" does not exist", category)
+	}
+	if op.Category == category {
+		return nil
+	}
+
+	p.moveClusterOperations(op, category, op.ClusterName())
+	return nil
+}
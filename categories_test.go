@@ -0,0 +1,198 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCategoryPaths(t *testing.T) {
+	tests := []struct {
+		parent, name string
+		path         string
+	}{
+		{"", "Товары", "Товары"},
+		{"Товары", "Матрасы", "Товары/Матрасы"},
+		{"Товары/Матрасы", "Детские", "Товары/Матрасы/Детские"},
+	}
+	for _, test := range tests {
+		path := joinCategoryPath(test.parent, test.name)
+		if path != test.path {
+			t.Errorf("%s + %s: path %s, want %s", test.parent, test.name, path, test.path)
+		}
+		if parent, name := splitCategoryPath(path); parent != test.parent || name != test.name {
+			t.Errorf("%s: split into %q and %q", path, parent, name)
+		}
+		if !isCategoryInside(path, test.parent) && test.parent != "" {
+			t.Errorf("%s is not inside %s", path, test.parent)
+		}
+	}
+	if isCategoryInside("Товары2", "Товары") {
+		t.Error("a category is inside another one with a longer name")
+	}
+}
+
+func TestAddCategory(t *testing.T) {
+	p := newTestProject(t, "buy shoes")
+	defer closeTestProject(t, p)
+	tests := []struct {
+		parent, name string
+		path         string // empty if the category can't be added
+	}{
+		{"", " Обувь ", "Обувь"},
+		{"Обувь", "Кеды", "Обувь/Кеды"},
+		{"", "Обувь", ""},
+		{"Обувь", "Кеды", ""},
+		{"", "removed", ""},
+		{"Обувь", "removed", "Обувь/removed"},
+		{"", "a/b", ""},
+		{"", "  ", ""},
+		{"", "..", ""},
+		{"Одежда", "Куртки", ""},
+		// Names which make the same directory are the same category
+		{"", "Обувь?", "Обувь?"},
+		{"", "Обувь*", ""},
+	}
+	for _, test := range tests {
+		path, err := p.AddCategory(test.parent, test.name)
+		if test.path == "" {
+			if err == nil {
+				t.Errorf("%s + %q: category %s is added", test.parent, test.name, path)
+			}
+			continue
+		}
+		if err != nil || path != test.path {
+			t.Errorf("%s + %q: path %s, want %s, error %v", test.parent, test.name, path, test.path, err)
+		}
+	}
+	want := "Обувь, Обувь/Кеды, Обувь/removed, Обувь?"
+	if paths := strings.Join(p.CategoryPaths(), ", "); paths != want {
+		t.Errorf("categories %s, want %s", paths, want)
+	}
+	if dir := p.CategoryDir("Обувь?/x"); dir != filepath.Join(p.Paths.ClustersDir, "Обувь_", "x") {
+		t.Errorf("directory of the category is %s", dir)
+	}
+}
+
+func TestMoveCategory(t *testing.T) {
+	p := newTestProject(t, "buy shoes", "red boots", "blue hat")
+	defer closeTestProject(t, p)
+	for _, path := range []string{"Обувь", "Обувь/Ботинки", "Одежда"} {
+		parent, name := splitCategoryPath(path)
+		if _, err := p.AddCategory(parent, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Category = "Обувь/Ботинки"
+	boots := cutQuery(t, p, "boots", OperationAdd)
+	p.Category = "Обувь"
+	shoes := cutQuery(t, p, "shoes", OperationAdd)
+	p.Category = ""
+	hat := cutQuery(t, p, "hat", OperationAdd)
+
+	tests := []struct {
+		move  func() (string, error)
+		path  string // empty if the category can't be moved
+		shoes string
+		boots string
+	}{
+		{func() (string, error) { return p.MoveCategory("Обувь", "Обувь/Ботинки") }, "", "", ""},
+		{func() (string, error) { return p.MoveCategory("Обувь", "Нет") }, "", "", ""},
+		{func() (string, error) { return p.RenameCategory("Обувь", "Одежда") }, "", "", ""},
+		{func() (string, error) { return p.RenameCategory("Обувь", "Обувь") }, "Обувь",
+			"clusters/Обувь/shoes.csv", "clusters/Обувь/Ботинки/boots.csv"},
+		{func() (string, error) { return p.RenameCategory("Обувь", "Туфли") }, "Туфли",
+			"clusters/Туфли/shoes.csv", "clusters/Туфли/Ботинки/boots.csv"},
+		{func() (string, error) { return p.MoveCategory("Туфли", "Одежда") }, "Одежда/Туфли",
+			"clusters/Одежда/Туфли/shoes.csv", "clusters/Одежда/Туфли/Ботинки/boots.csv"},
+		{func() (string, error) { return p.MoveCategory("Одежда/Туфли/Ботинки", "") }, "Ботинки",
+			"clusters/Одежда/Туфли/shoes.csv", "clusters/Ботинки/boots.csv"},
+	}
+	for i, test := range tests {
+		path, err := test.move()
+		if test.path == "" {
+			if err == nil {
+				t.Errorf("%d: category is moved into %s", i, path)
+			}
+			continue
+		}
+		if err != nil || path != test.path {
+			t.Errorf("%d: path %s, want %s, error %v", i, path, test.path, err)
+			continue
+		}
+		if err := p.flush(); err != nil {
+			t.Fatal(err)
+		}
+		for _, op := range []*KeywordOperation{shoes, boots} {
+			want := test.shoes
+			if op == boots {
+				want = test.boots
+			}
+			if op.File != filepath.FromSlash(want) {
+				t.Errorf("%d: file of %s is %s, want %s", i, op.Keyword, op.File, want)
+			}
+			if !fileExists(filepath.Join(p.Paths.Dir, op.File)) {
+				t.Errorf("%d: file %s doesn't exist", i, op.File)
+			}
+		}
+	}
+	if hat.File != filepath.Join("clusters", "hat.csv") {
+		t.Errorf("cluster out of the categories is moved into %s", hat.File)
+	}
+
+	// A directory which isn't a category is never overwritten
+	if err := os.MkdirAll(p.CategoryDir("Шапки"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RenameCategory("Ботинки", "Шапки"); err == nil {
+		t.Error("category is renamed onto an existing directory")
+	}
+	if !p.CategoryExists("Ботинки") || p.CategoryExists("Шапки") {
+		t.Error("category is changed by a failed rename")
+	}
+}
+
+func TestRemoveCategory(t *testing.T) {
+	p := newTestProject(t, "buy shoes", "blue hat")
+	defer closeTestProject(t, p)
+	for _, path := range []string{"Обувь", "Одежда", "Одежда/Шапки"} {
+		parent, name := splitCategoryPath(path)
+		if _, err := p.AddCategory(parent, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Category = "Обувь"
+	shoes := cutQuery(t, p, "shoes", OperationAdd)
+	p.Undo()
+
+	tests := []struct {
+		path    string
+		removed bool
+	}{
+		{"Нет", false},
+		// Clusters of the undone operations are kept by the categories
+		{"Обувь", false},
+		{"Одежда", false},
+		{"Одежда/Шапки", true},
+		{"Одежда", true},
+	}
+	for _, test := range tests {
+		if err := p.RemoveCategory(test.path); (err == nil) != test.removed {
+			t.Errorf("%s: error %v, want removed %v", test.path, err, test.removed)
+		}
+	}
+	if paths := strings.Join(p.CategoryPaths(), ", "); paths != "Обувь" {
+		t.Errorf("categories %s after the removal", paths)
+	}
+
+	if err := p.MoveCluster(shoes, "Нет"); err == nil {
+		t.Error("cluster is moved into a missing category")
+	}
+	if err := p.MoveCluster(shoes, ""); err != nil || shoes.Category != "" {
+		t.Errorf("cluster is moved into %q, error %v", shoes.Category, err)
+	}
+	if err := p.RemoveCategory("Обувь"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+	"strings"
+)
+
+// Title of the category list with its hotkeys
+const categoriesTitle = "Категории: Enter — сохранять сюда, N — новая, R — переименовать, M — переместить, D — удалить"
+
+// categoriesList shows the category tree. Enter chooses the category which new clusters are cut into,
+// the other hotkeys edit the tree. Done is called with false if the list is closed by Esc.
+func categoriesList(app *App, pages *tview.Pages, done func(path string, ok bool)) *SimpleList {
+	project := &app.State.Project
+	list := NewSimpleList()
+	list.SetBorder(true).SetTitle(categoriesTitle).SetBorderPadding(0, 0, 1, 1)
+
+	// Paths of the items, the first one is the root
+	var paths []string
+	fill := func(selected string) {
+		paths = append([]string{""}, project.CategoryPaths()...)
+		list.Clear()
+		for i, path := range paths {
+			path := path
+			name := "Без категории"
+			var indent string
+			if path != "" {
+				_, name = splitCategoryPath(path)
+				indent = strings.Repeat("  ", strings.Count(path, categorySeparator)+1)
+			}
+			var pointer string
+			if path == project.Category {
+				pointer = " <-- текущая"
+			}
+			list.AddItem(fmt.Sprintf("%s[yellow]%s[white] — кластеров: %d [gray]%s[white]%s", indent, tview.Escape(name),
+				len(project.ClusterNames(path)), tview.Escape(project.relativePath(project.CategoryDir(path))), pointer), func() {
+				done(path, true)
+			})
+			if path == selected {
+				list.SetCurrentItem(i)
+			}
+		}
+	}
+	fill(project.Category)
+
+	closeDialog := func(err error) {
+		pages.RemovePage("CategoryDialog")
+		pages.SwitchToPage("Categories")
+		app.UI.SetFocus(list)
+		if err != nil {
+			list.SetTitle("[red]" + tview.Escape(err.Error()))
+		} else {
+			list.SetTitle(categoriesTitle)
+		}
+	}
+
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		path := paths[list.GetCurrentItem()]
+		switch {
+		case event.Key() == tcell.KeyEscape:
+			done("", false)
+		case event.Rune() == 'n' || event.Rune() == 'т':
+			title := "Новая категория"
+			if path != "" {
+				title += " в " + path
+			}
+			input := textInput(title, " Название: ", "", func(name string, ok bool) {
+				var err error
+				if ok {
+					var created string
+					if created, err = project.AddCategory(path, name); err == nil {
+						fill(created)
+					}
+				}
+				closeDialog(err)
+			})
+			pages.AddAndSwitchToPage("CategoryDialog", input, true)
+			return nil
+		case (event.Rune() == 'r' || event.Rune() == 'к') && path != "":
+			_, name := splitCategoryPath(path)
+			input := textInput("Переименование категории", " Название: ", name, func(name string, ok bool) {
+				var err error
+				if ok {
+					var renamed string
+					if renamed, err = project.RenameCategory(path, name); err == nil {
+						fill(renamed)
+					}
+				}
+				closeDialog(err)
+			})
+			pages.AddAndSwitchToPage("CategoryDialog", input, true)
+			return nil
+		case (event.Rune() == 'm' || event.Rune() == 'ь') && path != "":
+			parent, _ := splitCategoryPath(path)
+			input := textInput("Перемещение категории "+path+", пусто — на верхний уровень", " В категорию: ", parent,
+				func(parent string, ok bool) {
+					var err error
+					if ok {
+						var moved string
+						if moved, err = project.MoveCategory(path, strings.Trim(parent, categorySeparator+" ")); err == nil {
+							fill(moved)
+						}
+					}
+					closeDialog(err)
+				})
+			input.SetAutocompleteFunc(func(text string) []string {
+				text = strings.ToLower(strings.TrimSpace(text))
+				if text == "" {
+					return nil
+				}
+				var entries []string
+				for _, other := range project.CategoryPaths() {
+					if !isCategoryInside(other, path) && strings.Contains(strings.ToLower(other), text) {
+						entries = append(entries, other)
+					}
+				}
+				return entries
+			})
+			pages.AddAndSwitchToPage("CategoryDialog", input, true)
+			return nil
+		case (event.Rune() == 'd' || event.Rune() == 'в' || event.Key() == tcell.KeyDelete) && path != "":
+			parent, _ := splitCategoryPath(path)
+			err := project.RemoveCategory(path)
+			if err == nil {
+				fill(parent)
+				list.SetTitle(categoriesTitle)
+			} else {
+				list.SetTitle("[red]" + tview.Escape(err.Error()))
+			}
+			return nil
+		}
+		return event
+	})
+	return list
+}
@@ -3,16 +3,57 @@ package main
 import (
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
+// Subclusters with less keywords are hidden by default
+const DefaultMinClusterSize = 3
+
+// Orders of the cluster tree nodes
+const (
+	ClusterSortCount     = "count"     // by number of keywords
+	ClusterSortFrequency = "frequency" // by summed broad frequency
+	ClusterSortExact     = "exact"     // by summed strong frequency
+	ClusterSortName      = "name"      // alphabetically
+)
+
+// Orders in the order they are switched in the tree
+var clusterSorts = []string{ClusterSortCount, ClusterSortFrequency, ClusterSortExact, ClusterSortName}
+
 type Cluster struct {
 	Rows []*Row
 	Hash string
+	// Index of the project rows, shared by all clusters of the tree
+	Index *WordIndex
+
+	stats       ClusterStats
+	statsOnce   sync.Once
+	statsReady  int32
+	statsQueued int32
+}
+
+// ClusterStats are aggregate metrics of the cluster rows
+type ClusterStats struct {
+	Keywords        int
+	Frequency       uint64
+	StrongFrequency uint64
+	// Total number of words of all keywords
+	Words int
+}
+
+func (s ClusterStats) AverageWords() float64 {
+	if s.Keywords == 0 {
+		return 0
+	}
+	return float64(s.Words) / float64(s.Keywords)
 }
 
 func NewCluster(name string, rows []*Row, parent *Cluster) *Cluster {
 	var hash string
+	var index *WordIndex
 	if parent != nil {
+		index = parent.Index
 		words := strings.Fields(parent.Hash)
 		words = append(words, name)
 		sort.Strings(words)
@@ -20,7 +61,7 @@ func NewCluster(name string, rows []*Row, parent *Cluster) *Cluster {
 	} else {
 		hash = name
 	}
-	return &Cluster{Hash: hash, Rows: rows}
+	return &Cluster{Hash: hash, Rows: rows, Index: index}
 }
 
 func (c *Cluster) GenerateSubClusters(existedClusterNodes map[string]*Cluster, minKeywords uint) map[string]*Cluster {
@@ -30,16 +71,34 @@ func (c *Cluster) GenerateSubClusters(existedClusterNodes map[string]*Cluster, m
 	}
 
 	parent := c
-	rows := c.Rows
+	index := c.Index
+	if index == nil {
+		index = NewWordIndex(c.Rows)
+	}
+	// Every subcluster is the intersection of the cluster rows with the posting list of a word
 	wordMap := make(map[string][]*Row)
-	for _, row := range rows {
-		words := strings.Fields(row.NormalizedKeyword)
-		for _, word := range words {
-			val, ok := wordMap[word]
-			if ok {
-				wordMap[word] = append(val, row)
-			} else {
-				wordMap[word] = []*Row{row}
+	for id, rows := range index.Group(c.Rows) {
+		if word := index.Word(id); !index.IsStopWord(word) {
+			wordMap[word] = rows
+		}
+	}
+
+	// Words of a synonym group make one subcluster named by the canonical word
+	if index.synonyms != nil {
+		merged := make(map[string][]string)
+		for word := range wordMap {
+			canonical := index.synonyms.Canonical(word)
+			merged[canonical] = append(merged[canonical], word)
+		}
+		for canonical, words := range merged {
+			if len(words) == 1 && words[0] == canonical {
+				continue
+			}
+			wordMap[canonical] = mergeGroupRows(c.Rows, wordMap, words)
+			for _, word := range words {
+				if word != canonical {
+					delete(wordMap, word)
+				}
 			}
 		}
 	}
@@ -48,6 +107,7 @@ func (c *Cluster) GenerateSubClusters(existedClusterNodes map[string]*Cluster, m
 	excludeWords := strings.Fields(parent.Hash)
 	for _, v := range excludeWords {
 		delete(wordMap, v)
+		delete(wordMap, index.synonyms.Canonical(v))
 	}
 
 	getHash := func(words []string) string {
@@ -63,7 +123,7 @@ func (c *Cluster) GenerateSubClusters(existedClusterNodes map[string]*Cluster, m
 		if v, ok := existedClusterNodes[hash]; ok == true {
 			cluster = v
 		} else {
-			cluster = &Cluster{Rows: wordMap[k], Hash: hash}
+			cluster = &Cluster{Rows: wordMap[k], Hash: hash, Index: c.Index}
 			existedClusterNodes[hash] = cluster
 		}
 		if len(cluster.Rows) >= int(minKeywords) {
@@ -73,4 +133,48 @@ func (c *Cluster) GenerateSubClusters(existedClusterNodes map[string]*Cluster, m
 	return clusters
 }
 
+// mergeGroupRows returns the rows of any of the words in the order of the cluster rows
+func mergeGroupRows(rows []*Row, wordMap map[string][]*Row, words []string) []*Row {
+	set := make(map[*Row]struct{})
+	for _, word := range words {
+		for _, row := range wordMap[word] {
+			set[row] = struct{}{}
+		}
+	}
+	merged := make([]*Row, 0, len(set))
+	for _, row := range rows {
+		if _, ok := set[row]; ok {
+			merged = append(merged, row)
+		}
+	}
+	return merged
+}
 
+// Stats computes the metrics once, rows of a cluster are never changed
+func (c *Cluster) Stats() ClusterStats {
+	c.statsOnce.Do(func() {
+		stats := ClusterStats{Keywords: len(c.Rows)}
+		for _, row := range c.Rows {
+			stats.Frequency += uint64(row.Frequency)
+			stats.StrongFrequency += uint64(row.StrongFrequency)
+			stats.Words += len(strings.Fields(row.Keyword))
+		}
+		c.stats = stats
+		atomic.StoreInt32(&c.statsReady, 1)
+	})
+	return c.stats
+}
+
+// CachedStats returns the metrics if they are already computed, it never blocks
+func (c *Cluster) CachedStats() (ClusterStats, bool) {
+	if atomic.LoadInt32(&c.statsReady) == 0 {
+		return ClusterStats{}, false
+	}
+	return c.stats, true
+}
+
+// queueStats marks the cluster as queued for the background computation.
+// It returns false if the metrics are already computed or queued.
+func (c *Cluster) queueStats() bool {
+	return atomic.LoadInt32(&c.statsReady) == 0 && atomic.CompareAndSwapInt32(&c.statsQueued, 0, 1)
+}
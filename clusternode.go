@@ -112,8 +112,8 @@ func (n *ClusterNode) SetName(text string) *ClusterNode {
 }
 
 // Parameter 'existedCluster' can be nil
-func (n *ClusterNode) GenerateChildren(existedClusters map[string]*Cluster) (ret []*ClusterNode) {
-	nodeMap := n.Cluster.GenerateSubClusters(existedClusters, 3)
+func (n *ClusterNode) GenerateChildren(existedClusters map[string]*Cluster, minKeywords uint) (ret []*ClusterNode) {
+	nodeMap := n.Cluster.GenerateSubClusters(existedClusters, minKeywords)
 	for key, node := range nodeMap {
 		cluster := NewClusterNode(key, node, false, n)
 		//if n.State == NodeRemoved {
@@ -129,11 +129,12 @@ func (n *ClusterNode) GetFullName() string {
 	return strings.Join(s, " ")
 }
 
+// Names with query syntax are grouped, so the joined names are a valid query
 func (n *ClusterNode) GetClusterNames() []string {
-	names := []string{n.Name}
+	names := []string{queryGroup(n.Name)}
 	node := n
 	for node.Parent != nil && node.Parent.Hash != "" {
-		names = append(names, node.Parent.Name)
+		names = append(names, queryGroup(node.Parent.Name))
 		node = node.Parent
 	}
 	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+	"strings"
+)
+
+// Title of the saved cluster list with its hotkeys
+const savedClustersTitle = "Кластеры: Enter — запросы, R — вернуть в остаток, M — объединить с другим кластером"
+
+// clustersPage lists the saved and removed clusters of the applied operations with their keywords.
+// Keywords or whole clusters are returned to the remaining ones, clusters are merged.
+func clustersPage(app *App, pages *tview.Pages, done func()) tview.Primitive {
+	project := &app.State.Project
+	list := NewSimpleList()
+	list.SetBorder(true).SetTitle(savedClustersTitle).SetBorderPadding(0, 0, 1, 1)
+	keywords := NewKeywordList(nil)
+	keywords.SetBorderPadding(0, 0, 1, 0).SetBorder(true).SetTitle("Запросы кластера")
+
+	var clusters []*SavedCluster
+	showKeywords := func(index int) {
+		if index >= len(clusters) {
+			keywords.SetKeywords(nil)
+			return
+		}
+		rows := clusters[index].Rows()
+		sortRowsByStrongVolume(rows)
+		items := make([]KeywordListItem, len(rows))
+		for i, row := range rows {
+			items[i] = KeywordListItem{Text: row.Keyword, Volume: row.Frequency, StrongVolume: row.StrongFrequency, Row: row}
+		}
+		keywords.SetKeywords(items)
+		keywords.SetTitle("Запросы кластера " + tview.Escape(clusters[index].Label) + " (R — вернуть выбранные в остаток)")
+	}
+	fill := func(selected string) {
+		clusters = project.SavedClusters()
+		list.Clear()
+		current := 0
+		for i, cluster := range clusters {
+			rows := cluster.Rows()
+			var frequency uint64
+			for _, row := range rows {
+				frequency += uint64(row.Frequency)
+			}
+			color := "[green]"
+			if cluster.Operation != OperationAdd {
+				color = "[red]"
+			}
+			list.AddItem(fmt.Sprintf("%s%s[white] — запросов: %d, частотность: %d", color, tview.Escape(cluster.Label),
+				len(rows), frequency), func() {
+				app.UI.SetFocus(keywords)
+			})
+			if cluster.Label == selected {
+				current = i
+			}
+		}
+		list.SetCurrentItem(current)
+		if len(clusters) == 0 {
+			showKeywords(0)
+		}
+	}
+	list.SetChangedFunc(showKeywords)
+	fill("")
+
+	// restore returns the rows of the cluster to the remaining ones and refreshes the lists
+	restore := func(cluster *SavedCluster, rows []*Row) {
+		if len(rows) == 0 {
+			return
+		}
+		app.RestoreRows(cluster, rows)
+		fill(cluster.Label)
+		list.SetTitle(fmt.Sprintf("Возвращено в остаток из %s: %d", tview.Escape(cluster.Label), len(rows)))
+	}
+
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		list.SetTitle(savedClustersTitle)
+		var cluster *SavedCluster
+		if list.GetCurrentItem() < len(clusters) {
+			cluster = clusters[list.GetCurrentItem()]
+		}
+		switch {
+		case event.Key() == tcell.KeyEscape:
+			done()
+			return nil
+		case event.Key() == tcell.KeyTab:
+			app.UI.SetFocus(keywords)
+			return nil
+		case (event.Rune() == 'r' || event.Rune() == 'к') && cluster != nil:
+			restore(cluster, cluster.Rows())
+			return nil
+		case (event.Rune() == 'm' || event.Rune() == 'ь') && cluster != nil:
+			input := textInput("Объединение "+cluster.Label+" с кластером", " Кластер: ", "", func(label string, ok bool) {
+				pages.RemovePage("SavedClusterDialog")
+				pages.SwitchToPage("Clusters")
+				app.UI.SetFocus(list)
+				if !ok {
+					return
+				}
+				label = strings.TrimSpace(label)
+				for _, target := range clusters {
+					if target.Label != label {
+						continue
+					}
+					if err := app.MergeClusters(cluster, target); err != nil {
+						list.SetTitle("[red]" + tview.Escape(err.Error()))
+					} else {
+						fill(target.Label)
+						list.SetTitle(fmt.Sprintf("Кластер %s объединен с %s", tview.Escape(cluster.Label), tview.Escape(target.Label)))
+					}
+					return
+				}
+				list.SetTitle("[red]Нет кластера " + tview.Escape(label))
+			})
+			input.SetAutocompleteFunc(func(text string) []string {
+				text = strings.ToLower(strings.TrimSpace(text))
+				if text == "" {
+					return nil
+				}
+				var entries []string
+				for _, other := range clusters {
+					if other != cluster && other.Operation == cluster.Operation &&
+						strings.Contains(strings.ToLower(other.Label), text) {
+						entries = append(entries, other.Label)
+					}
+				}
+				return entries
+			})
+			pages.AddAndSwitchToPage("SavedClusterDialog", input, true)
+			return nil
+		}
+		return event
+	})
+
+	keywords.SetControlFunc(func(event *tcell.EventKey) {
+		switch {
+		case event.Key() == tcell.KeyTab || event.Key() == tcell.KeyBacktab:
+			app.UI.SetFocus(list)
+		case event.Rune() == 'r' || event.Rune() == 'к':
+			if list.GetCurrentItem() < len(clusters) {
+				restore(clusters[list.GetCurrentItem()], keywords.SelectedRows())
+			}
+		}
+	})
+
+	flex := tview.NewFlex()
+	flex.SetDirection(tview.FlexColumn).
+		AddItem(list, 0, 1, true).
+		AddItem(keywords, 0, 2, false)
+	return flex
+}
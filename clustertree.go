@@ -6,9 +6,41 @@ import (
 	"fmt"
 	"github.com/gdamore/tcell"
 	"github.com/rivo/tview"
+	"runtime/debug"
 	"strings"
+	"sync"
 )
 
+// Metrics which can be shown next to the node names
+const (
+	TreeColumnCount     = "count"     // number of keywords
+	TreeColumnFrequency = "frequency" // summed broad frequency
+	TreeColumnExact     = "exact"     // summed strong frequency
+	TreeColumnShare     = "share"     // share of the root broad frequency
+	TreeColumnWords     = "words"     // average number of words per keyword
+)
+
+var treeColumns = []string{TreeColumnCount, TreeColumnFrequency, TreeColumnExact, TreeColumnShare, TreeColumnWords}
+
+var defaultTreeColumns = []string{TreeColumnCount, TreeColumnFrequency}
+
+// treeColumnName returns the title of the column for the settings list
+func treeColumnName(column string) string {
+	switch column {
+	case TreeColumnCount:
+		return "Количество запросов"
+	case TreeColumnFrequency:
+		return "Широкая частотность"
+	case TreeColumnExact:
+		return "Строгая частотность"
+	case TreeColumnShare:
+		return "Доля частотности корневого кластера"
+	case TreeColumnWords:
+		return "Среднее число слов в запросе"
+	}
+	return column
+}
+
 // Tree navigation events.
 const (
 	treeNone int = iota
@@ -48,6 +80,25 @@ type ClusterTreeView struct {
 
 	controlCallback func(node *ClusterNode, key *tcell.EventKey)
 
+	// Metrics shown next to the node names
+	columns []string
+
+	// An optional function which is called from another goroutine when
+	// the metrics of the drawn nodes are computed, so the tree can be redrawn.
+	statsCallback func()
+
+	// An optional function which is called with a recovered panic of the background goroutine
+	panicCallback func(value interface{}, stack []byte)
+
+	// Clusters waiting for their metrics. They are computed by one worker goroutine,
+	// which is running while the queue isn't empty.
+	statsMutex   sync.Mutex
+	statsQueue   []*Cluster
+	statsRunning bool
+
+	// Whether the tree of a new query is computed in the background
+	computing bool
+
 	// The visible nodes, top-down, as set by process().
 	nodes []*ClusterNode
 }
@@ -120,6 +171,32 @@ func (t *ClusterTreeView) SetControlFunc(handler func(node *ClusterNode, key *tc
 	return t
 }
 
+// SetComputing shows that the tree of a new query is computed, the current tree is kept until then
+func (t *ClusterTreeView) SetComputing(computing bool) *ClusterTreeView {
+	t.computing = computing
+	return t
+}
+
+// SetColumns sets the metrics shown next to the node names, see treeColumns
+func (t *ClusterTreeView) SetColumns(columns []string) *ClusterTreeView {
+	t.columns = columns
+	return t
+}
+
+// SetPanicFunc sets the handler of a panic of the background goroutine computing the metrics.
+// Without it the panic crashes the program.
+func (t *ClusterTreeView) SetPanicFunc(handler func(value interface{}, stack []byte)) *ClusterTreeView {
+	t.panicCallback = handler
+	return t
+}
+
+// SetStatsFunc sets the handler which is called from another goroutine
+// when metrics of the drawn nodes are computed.
+func (t *ClusterTreeView) SetStatsFunc(handler func()) *ClusterTreeView {
+	t.statsCallback = handler
+	return t
+}
+
 // process builds the visible tree, populates the "nodes" slice, and processes
 // pending selection actions.
 func (t *ClusterTreeView) process() {
@@ -258,6 +335,13 @@ func (t *ClusterTreeView) Draw(screen tcell.Screen) {
 		t.offsetY = 0
 	}
 
+	// Metrics are computed in the background, the nodes without them are drawn with a placeholder
+	var pending []*Cluster
+	rootStats, rootReady := t.root.CachedStats()
+	if len(t.columns) > 0 && !rootReady {
+		pending = append(pending, t.root.Cluster)
+	}
+
 	posY := y
 	for index, node := range t.nodes {
 		// Skip invisible parts.
@@ -274,6 +358,14 @@ func (t *ClusterTreeView) Draw(screen tcell.Screen) {
 		}
 		var line string
 		text := node.Name
+		if len(t.columns) > 0 {
+			if stats, ok := node.CachedStats(); ok {
+				text += " [gray]" + t.formatStats(stats, rootStats, rootReady)
+			} else {
+				text += " [gray]…"
+				pending = append(pending, node.Cluster)
+			}
+		}
 		indent := strings.Repeat(" ", node.level*3)
 		line = fmt.Sprintf(` %s%s[•] %s `, color, indent, text)
 		tview.Print(screen, line, x, posY, width, tview.AlignLeft, tcell.ColorWhite)
@@ -281,6 +373,83 @@ func (t *ClusterTreeView) Draw(screen tcell.Screen) {
 		// Advance.
 		posY++
 	}
+
+	t.computeStats(pending)
+
+	if t.computing {
+		tview.Print(screen, "[yellow::b] вычисление… ", x, y, width, tview.AlignRight, tcell.ColorYellow)
+	}
+}
+
+// formatStats joins the selected metrics of the node
+func (t *ClusterTreeView) formatStats(stats, root ClusterStats, rootReady bool) string {
+	values := make([]string, 0, len(t.columns))
+	for _, column := range t.columns {
+		switch column {
+		case TreeColumnCount:
+			values = append(values, fmt.Sprint(stats.Keywords))
+		case TreeColumnFrequency:
+			values = append(values, fmt.Sprint(stats.Frequency))
+		case TreeColumnExact:
+			values = append(values, fmt.Sprintf("!%d", stats.StrongFrequency))
+		case TreeColumnShare:
+			if rootReady && root.Frequency > 0 {
+				values = append(values, fmt.Sprintf("%.1f%%", float64(stats.Frequency)*100/float64(root.Frequency)))
+			} else {
+				values = append(values, "…%")
+			}
+		case TreeColumnWords:
+			values = append(values, fmt.Sprintf("%.1f сл.", stats.AverageWords()))
+		}
+	}
+	return strings.Join(values, " · ")
+}
+
+// computeStats queues the clusters for the worker computing metrics in the background,
+// so one computation runs at a time however often the tree is drawn
+func (t *ClusterTreeView) computeStats(clusters []*Cluster) {
+	t.statsMutex.Lock()
+	defer t.statsMutex.Unlock()
+	for _, cluster := range clusters {
+		if cluster.queueStats() {
+			t.statsQueue = append(t.statsQueue, cluster)
+		}
+	}
+	if len(t.statsQueue) > 0 && !t.statsRunning {
+		t.statsRunning = true
+		go t.runStats()
+	}
+}
+
+// runStats computes the queued clusters by batches and notifies the tree after every batch.
+// It stops when the queue is empty.
+func (t *ClusterTreeView) runStats() {
+	defer func() {
+		if r := recover(); r != nil {
+			if t.panicCallback == nil {
+				panic(r)
+			}
+			t.panicCallback(r, debug.Stack())
+		}
+	}()
+	for {
+		t.statsMutex.Lock()
+		batch := t.statsQueue
+		t.statsQueue = nil
+		if len(batch) == 0 {
+			t.statsRunning = false
+			t.statsMutex.Unlock()
+			return
+		}
+		t.statsMutex.Unlock()
+
+		for _, cluster := range batch {
+			cluster.Stats()
+		}
+		if t.statsCallback != nil {
+			t.statsCallback()
+		}
+	}
 }
 
 // InputHandler returns the handler for this primitive.
@@ -311,7 +480,7 @@ func (t *ClusterTreeView) InputHandler() func(event *tcell.EventKey, setFocus fu
 			event.Modifiers()&tcell.ModShift != 0 ||
 			event.Key() == tcell.KeyBackspace || event.Key() == tcell.KeyBackspace2 ||
 			event.Key() == tcell.KeyCtrlBackslash ||
-			event.Rune() == '-' || event.Rune() == '+' || event.Rune() == '/' {
+			event.Rune() == '-' || event.Rune() == '+' || event.Rune() == '/' || event.Rune() == '!' || event.Rune() == '>' {
 			if t.controlCallback != nil {
 				t.controlCallback(t.currentNode, event)
 			}
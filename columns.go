@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Canonical headers of the Row fields. They are used in every file written
+// by the project, so the project files can always be read back without mapping.
+const (
+	ColumnLemma           = "Лемма"
+	ColumnKeyword         = "Ключевое слово"
+	ColumnFrequency       = "Широкая частотность"
+	ColumnStrongFrequency = "Строгая частотность"
+)
+
+// ColumnMapping binds header names of a source file to the Row fields.
+// Empty fields are resolved by auto-detection.
+type ColumnMapping struct {
+	Keyword         string `json:"keyword,omitempty"`
+	Lemma           string `json:"lemma,omitempty"`
+	Frequency       string `json:"frequency,omitempty"`
+	StrongFrequency string `json:"strong_frequency,omitempty"`
+}
+
+// ColumnPreset is a known header set of some keyword tool export
+type ColumnPreset struct {
+	Name    string
+	Columns ColumnMapping
+}
+
+// Known header sets. Headers are compared case-insensitively.
+var columnPresets = []ColumnPreset{
+	{
+		Name: "Seoterminal",
+		Columns: ColumnMapping{
+			Keyword:         ColumnKeyword,
+			Lemma:           ColumnLemma,
+			Frequency:       ColumnFrequency,
+			StrongFrequency: ColumnStrongFrequency,
+		},
+	},
+	{
+		Name: "Key Collector",
+		Columns: ColumnMapping{
+			Keyword:         "Фраза",
+			Frequency:       "Базовая частотность [YW]",
+			StrongFrequency: "\"!\" частотность [YW]",
+		},
+	},
+	{
+		Name: "Yandex Wordstat",
+		Columns: ColumnMapping{
+			Keyword:   "Ключевые слова",
+			Frequency: "Показов в месяц",
+		},
+	},
+	{
+		Name: "Google Keyword Planner",
+		Columns: ColumnMapping{
+			Keyword:   "Keyword",
+			Frequency: "Avg. monthly searches",
+		},
+	},
+	{
+		Name: "Ahrefs",
+		Columns: ColumnMapping{
+			Keyword:   "Keyword",
+			Frequency: "Volume",
+		},
+	},
+	{
+		Name: "Semrush",
+		Columns: ColumnMapping{
+			Keyword:   "Keyword",
+			Frequency: "Search Volume",
+		},
+	},
+}
+
+// Merge returns the mapping where non-empty fields of other override the current ones
+func (m ColumnMapping) Merge(other ColumnMapping) ColumnMapping {
+	if other.Keyword != "" {
+		m.Keyword = other.Keyword
+	}
+	if other.Lemma != "" {
+		m.Lemma = other.Lemma
+	}
+	if other.Frequency != "" {
+		m.Frequency = other.Frequency
+	}
+	if other.StrongFrequency != "" {
+		m.StrongFrequency = other.StrongFrequency
+	}
+	return m
+}
+
+// Detect fills empty fields of the mapping from the preset
+// that matches the most columns of the header.
+func (m ColumnMapping) Detect(header []string) ColumnMapping {
+	var best ColumnMapping
+	bestScore := 0
+	for _, preset := range columnPresets {
+		if findColumn(header, preset.Columns.Keyword) < 0 {
+			continue
+		}
+		detected := preset.Columns
+		score := 1
+		// Presets may miss some columns of a concrete file
+		for _, column := range []*string{&detected.Lemma, &detected.Frequency, &detected.StrongFrequency} {
+			if findColumn(header, *column) < 0 {
+				*column = ""
+			} else {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = detected, score
+		}
+	}
+	// Explicitly specified columns win over the preset
+	return best.Merge(m)
+}
+
+// Resolve renames the header columns to the canonical Row headers.
+// Columns that are not mapped keep their names, unless these names
+// clash with the canonical ones.
+func (m ColumnMapping) Resolve(header []string) ([]string, error) {
+	resolved := make([]string, len(header))
+	for i, column := range header {
+		if isCanonicalColumn(column) {
+			// Could be mapped back below
+			continue
+		}
+		resolved[i] = column
+	}
+
+	bindings := []struct {
+		source    string
+		canonical string
+	}{
+		{m.Keyword, ColumnKeyword},
+		{m.Lemma, ColumnLemma},
+		{m.Frequency, ColumnFrequency},
+		{m.StrongFrequency, ColumnStrongFrequency},
+	}
+	for _, b := range bindings {
+		if b.source == "" {
+			continue
+		}
+		index := findColumn(header, b.source)
+		if index < 0 {
+			return nil, fmt.Errorf("column \"%s\" is not found in header: %s", b.source, strings.Join(header, ", "))
+		}
+		resolved[index] = b.canonical
+	}
+
+	if findColumn(resolved, ColumnKeyword) < 0 {
+		return nil, fmt.Errorf("keyword column is not detected in header: %s", strings.Join(header, ", "))
+	}
+
+	return resolved, nil
+}
+
+func isCanonicalColumn(column string) bool {
+	switch column {
+	case ColumnKeyword, ColumnLemma, ColumnFrequency, ColumnStrongFrequency:
+		return true
+	}
+	return false
+}
+
+// findColumn returns index of the column in header or -1
+func findColumn(header []string, column string) int {
+	if column == "" {
+		return -1
+	}
+	column = normalizeColumnName(column)
+	for i, h := range header {
+		if normalizeColumnName(h) == column {
+			return i
+		}
+	}
+	return -1
+}
+
+func normalizeColumnName(column string) string {
+	column = strings.TrimPrefix(column, "\ufeff")
+	return strings.ToLower(strings.TrimSpace(column))
+}
+
+// mapColumnValue prepares raw values for decoding.
+// Exports often contain formatted numbers like "1 000" or "1,000" and empty cells.
+func mapColumnValue(field, column string, v interface{}) string {
+	if _, ok := v.(uint32); !ok {
+		return field
+	}
+	var b strings.Builder
+	for _, r := range field {
+		if r == ' ' || r == ',' || r == '\u00a0' || r == '\u202f' {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 {
+		return "0"
+	}
+	return b.String()
+}
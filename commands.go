@@ -0,0 +1,553 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/jszwec/csvutil"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Subcommands inspect a project without the interface:
+//
+//	tool stats -p <project>
+//	tool search -p <project> [-limit N] [-all] <query>
+//	tool tree -p <project> [-top N] [-depth N] [query]
+//	tool history -p <project> [-all]
+//	tool export -p <project> [-o file] [-all] [query]
+//	tool structure -p <project> [-keywords]
+//
+// The project is loaded read-only, so it can be inspected while it's opened in the interface.
+// Every command prints text or JSON by the -format flag.
+var commands = map[string]func(args []string, w io.Writer) error{
+	"stats":     runStatsCommand,
+	"search":    runSearchCommand,
+	"tree":      runTreeCommand,
+	"history":   runHistoryCommand,
+	"export":    runExportCommand,
+	"structure": runStructureCommand,
+}
+
+// Output formats of the subcommands
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// commandFlags are the flags of a subcommand, -p and -format are shared by all of them
+type commandFlags struct {
+	*flag.FlagSet
+	project string
+	format  string
+}
+
+func newCommandFlags(name string) *commandFlags {
+	f := &commandFlags{FlagSet: flag.NewFlagSet(name, flag.ExitOnError)}
+	f.StringVar(&f.project, "p", "", "Project name")
+	f.StringVar(&f.format, "format", FormatText, "Output format: text or json")
+	return f
+}
+
+// load parses the arguments and loads the project read-only
+func (f *commandFlags) load(args []string) (*Project, error) {
+	f.Parse(args)
+	if err := f.checkQuery(args); err != nil {
+		return nil, err
+	}
+	if f.project == "" {
+		return nil, &usageError{"-p flag must be specified"}
+	}
+	if f.format != FormatText && f.format != FormatJSON {
+		return nil, &usageError{"-format must be text or json"}
+	}
+	return LoadProjectReadOnly(f.project)
+}
+
+// checkQuery rejects flags after the query. Parsing stops at the query, so they would be taken
+// for negative words of the query. Words after "--" are always the query.
+func (f *commandFlags) checkQuery(args []string) error {
+	query := f.Args()
+	if len(query) < len(args) && args[len(args)-len(query)-1] == "--" {
+		return nil
+	}
+	for _, arg := range query {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		if f.Lookup(name) != nil {
+			return &usageError{fmt.Sprintf("flag %s must be before the query, use -- before words which are flag names", arg)}
+		}
+	}
+	return nil
+}
+
+// query joins the arguments left after the flags
+func (f *commandFlags) query() string {
+	return strings.Join(f.Args(), " ")
+}
+
+func writeJSON(w io.Writer, value interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// rowJSON is a keyword in the JSON output
+type rowJSON struct {
+	Keyword   string `json:"keyword"`
+	Lemma     string `json:"lemma"`
+	Frequency uint32 `json:"frequency"`
+	Exact     uint32 `json:"exact"`
+}
+
+func rowsJSON(rows []*Row) []rowJSON {
+	ret := make([]rowJSON, len(rows))
+	for i, row := range rows {
+		ret[i] = rowJSON{row.Keyword, row.NormalizedKeyword, row.Frequency, row.StrongFrequency}
+	}
+	return ret
+}
+
+// commandRows selects the rows of the query from the remaining rows or from all rows of the project
+func commandRows(project *Project, query string, all bool) ([]*Row, error) {
+	if !all {
+		root, err := project.RootCluster(query)
+		if err != nil {
+			return nil, err
+		}
+		return root.Rows, nil
+	}
+	return selectRows(query, project.InitialRows, project.Normalizer, project.querySynonyms(project.expandSynonyms()))
+}
+
+// Stats
+
+type projectStats struct {
+	Keywords           int    `json:"keywords"`
+	Frequency          uint64 `json:"frequency"`
+	Remaining          int    `json:"remaining"`
+	RemainingFrequency uint64 `json:"remaining_frequency"`
+	// Keywords cut by the applied operations of the active branch
+	Clustered int `json:"clustered"`
+	Removed   int `json:"removed"`
+
+	Operations int    `json:"operations"`
+	Applied    int    `json:"applied"`
+	Branch     string `json:"branch"`
+	Branches   int    `json:"branches"`
+}
+
+func runStatsCommand(args []string, w io.Writer) error {
+	f := newCommandFlags("stats")
+	project, err := f.load(args)
+	if err != nil {
+		return err
+	}
+
+	all := NewCluster("", project.InitialRows, nil).Stats()
+	remaining := NewCluster("", project.Rows, nil).Stats()
+	stats := projectStats{
+		Keywords:           all.Keywords,
+		Frequency:          all.Frequency,
+		Remaining:          remaining.Keywords,
+		RemainingFrequency: remaining.Frequency,
+		Operations:         len(project.History.Operations),
+		Applied:            project.History.CurrentStateIndex + 1,
+		Branch:             project.History.Name,
+		Branches:           len(project.History.Branches),
+	}
+	// Restore operations take rows back from the clusters, so the rows left in them are counted
+	for _, op := range project.History.Operations[:stats.Applied] {
+		switch op.Operation {
+		case OperationAdd:
+			stats.Clustered += len(op.rows)
+		case OperationRemove, OperationSilentRemove:
+			stats.Removed += len(op.rows)
+		}
+	}
+
+	if f.format == FormatJSON {
+		return writeJSON(w, stats)
+	}
+	fmt.Fprintf(w, "Keywords:   %d, frequency %d\n", stats.Keywords, stats.Frequency)
+	fmt.Fprintf(w, "Remaining:  %d (%.1f%%), frequency %d\n", stats.Remaining, percent(stats.Remaining, stats.Keywords), stats.RemainingFrequency)
+	fmt.Fprintf(w, "Clustered:  %d\n", stats.Clustered)
+	fmt.Fprintf(w, "Removed:    %d\n", stats.Removed)
+	fmt.Fprintf(w, "History:    %d of %d operations are applied, branch \"%s\" of %d\n",
+		stats.Applied, stats.Operations, stats.Branch, stats.Branches)
+	return nil
+}
+
+func percent(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(total)
+}
+
+// Search
+
+func runSearchCommand(args []string, w io.Writer) error {
+	f := newCommandFlags("search")
+	limit := f.Int("limit", 0, "Maximum number of keywords, 0 prints all")
+	all := f.Bool("all", false, "Search all keywords of the project instead of the remaining ones")
+	project, err := f.load(args)
+	if err != nil {
+		return err
+	}
+
+	rows, err := commandRows(project, f.query(), *all)
+	if err != nil {
+		return err
+	}
+	rows = append([]*Row(nil), rows...)
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].Frequency > rows[j].Frequency
+	})
+	if *limit > 0 && len(rows) > *limit {
+		rows = rows[:*limit]
+	}
+
+	if f.format == FormatJSON {
+		return writeJSON(w, rowsJSON(rows))
+	}
+	for _, row := range rows {
+		fmt.Fprintf(w, "%s\t%d\t%d\n", row.Keyword, row.Frequency, row.StrongFrequency)
+	}
+	return nil
+}
+
+// Tree
+
+// clusterJSON is a node of the cluster tree in the JSON output
+type clusterJSON struct {
+	Name      string        `json:"name"`
+	Query     string        `json:"query"`
+	Keywords  int           `json:"keywords"`
+	Frequency uint64        `json:"frequency"`
+	Exact     uint64        `json:"exact"`
+	Children  []clusterJSON `json:"children,omitempty"`
+}
+
+func runTreeCommand(args []string, w io.Writer) error {
+	f := newCommandFlags("tree")
+	top := f.Int("top", 20, "Number of subclusters of every cluster, 0 prints all")
+	depth := f.Int("depth", 1, "Number of the tree levels")
+	minSize := f.Uint("min", 0, "Minimum cluster size, the project setting by default")
+	order := f.String("sort", "", "Order of subclusters: count, frequency, exact or name, the project setting by default")
+	project, err := f.load(args)
+	if err != nil {
+		return err
+	}
+	if *minSize == 0 {
+		*minSize = project.Config.MinClusterSize
+	}
+	if *order == "" {
+		*order = project.Config.ClusterSort
+	}
+	if !containsString(clusterSorts, *order) {
+		return &usageError{"-sort must be one of " + strings.Join(clusterSorts, ", ")}
+	}
+
+	query := f.query()
+	root, err := project.RootCluster(query)
+	if err != nil {
+		return err
+	}
+	node := NewClusterNode(query, root, true, nil)
+	tree := clusterTreeJSON(node, make(map[string]*Cluster), *depth, *top, *minSize, *order)
+
+	if f.format == FormatJSON {
+		return writeJSON(w, tree)
+	}
+	writeClusterTree(w, tree, "")
+	return nil
+}
+
+// clusterTreeJSON generates the subclusters of the node down to the depth as the interface does
+func clusterTreeJSON(node *ClusterNode, cache map[string]*Cluster, depth, top int, minSize uint, order string) clusterJSON {
+	stats := node.Stats()
+	ret := clusterJSON{
+		Name:      node.Name,
+		Query:     node.GetFullName(),
+		Keywords:  stats.Keywords,
+		Frequency: stats.Frequency,
+		Exact:     stats.StrongFrequency,
+	}
+	if depth <= 0 {
+		return ret
+	}
+	children := node.GenerateChildren(cache, minSize)
+	sortClusterNodes(children, order)
+	if top > 0 && len(children) > top {
+		children = children[:top]
+	}
+	for _, child := range children {
+		ret.Children = append(ret.Children, clusterTreeJSON(child, cache, depth-1, top, minSize, order))
+	}
+	return ret
+}
+
+func writeClusterTree(w io.Writer, node clusterJSON, indent string) {
+	name := node.Name
+	if name == "" {
+		name = "*"
+	}
+	fmt.Fprintf(w, "%s%s\t%d\t%d\t%d\n", indent, name, node.Keywords, node.Frequency, node.Exact)
+	for _, child := range node.Children {
+		writeClusterTree(w, child, indent+"  ")
+	}
+}
+
+// History
+
+// operationJSON is a history operation in the JSON output
+type operationJSON struct {
+	Number    int     `json:"number"`
+	Operation string  `json:"operation"`
+	Query     string  `json:"query"`
+	Applied   bool    `json:"applied"`
+	Time      *string `json:"time,omitempty"`
+	Rows      int     `json:"rows"`
+	Frequency uint64  `json:"frequency"`
+	File      string  `json:"file,omitempty"`
+	Cluster   string  `json:"cluster,omitempty"`
+	Category  string  `json:"category,omitempty"`
+	Note      string  `json:"note,omitempty"`
+	// Keywords of a row operation of the keyword list
+	Keywords []string `json:"keywords,omitempty"`
+	// Label of the cluster which is merged by the operation
+	Merge string `json:"merge,omitempty"`
+}
+
+// branchJSON is a history branch in the JSON output
+type branchJSON struct {
+	Name       string          `json:"name"`
+	Active     bool            `json:"active"`
+	Applied    int             `json:"applied"`
+	Operations []operationJSON `json:"operations"`
+}
+
+func runHistoryCommand(args []string, w io.Writer) error {
+	f := newCommandFlags("history")
+	all := f.Bool("all", false, "Print all branches instead of the active one")
+	project, err := f.load(args)
+	if err != nil {
+		return err
+	}
+
+	history := project.History
+	branches := []*HistoryBranch{history.HistoryBranch}
+	if *all {
+		branches = history.Branches
+	}
+
+	if f.format == FormatJSON {
+		ret := make([]branchJSON, 0, len(branches))
+		for _, branch := range branches {
+			b := branchJSON{
+				Name:       branch.Name,
+				Active:     branch == history.HistoryBranch,
+				Applied:    branch.CurrentStateIndex + 1,
+				Operations: make([]operationJSON, 0, len(branch.Operations)),
+			}
+			for i, op := range branch.Operations {
+				o := operationJSON{
+					Number:    i + 1,
+					Operation: operationNames[op.Operation],
+					Query:     op.Keyword,
+					Applied:   i <= branch.CurrentStateIndex,
+					Rows:      op.RowCount,
+					Frequency: op.Frequency,
+					File:      op.File,
+					Cluster:   op.Cluster,
+					Category:  op.Category,
+					Note:      op.Note,
+					Keywords:  op.Keywords,
+					Merge:     op.Merge,
+				}
+				if !op.Time.IsZero() {
+					t := op.Time.Format(time.RFC3339)
+					o.Time = &t
+				}
+				b.Operations = append(b.Operations, o)
+			}
+			ret = append(ret, b)
+		}
+		return writeJSON(w, ret)
+	}
+
+	for i, branch := range branches {
+		if *all {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			var active string
+			if branch == history.HistoryBranch {
+				active = " (active)"
+			}
+			fmt.Fprintf(w, "@ %s%s\n", branch.Name, active)
+		}
+		for j, op := range branch.Operations {
+			var pointer string
+			if j == branch.CurrentStateIndex {
+				pointer = " <-- current"
+			}
+			details := []string{fmt.Sprintf("rows %d", op.RowCount), fmt.Sprintf("frequency %d", op.Frequency)}
+			if !op.Time.IsZero() {
+				details = append(details, op.Time.Local().Format("2006-01-02 15:04"))
+			}
+			if op.File != "" {
+				details = append(details, op.File)
+			}
+			if op.Merge != "" {
+				details = append(details, "merge of "+op.Merge)
+			} else if op.Keywords != nil {
+				details = append(details, "keyword list")
+			}
+			if op.Note != "" {
+				details = append(details, op.Note)
+			}
+			fmt.Fprintf(w, "%3d. %-2s %s%s\t%s\n", j+1, operationPrefixes[op.Operation], operationTarget(op), pointer, strings.Join(details, ", "))
+		}
+	}
+	return nil
+}
+
+// Export
+
+func runExportCommand(args []string, w io.Writer) error {
+	f := newCommandFlags("export")
+	output := f.String("o", "", "Output csv or xlsx file, the keywords are printed as csv or json if it's empty")
+	all := f.Bool("all", false, "Export all keywords of the project instead of the remaining ones")
+	project, err := f.load(args)
+	if err != nil {
+		return err
+	}
+
+	rows, err := commandRows(project, f.query(), *all)
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		if err := SaveRows(rows, *output); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%d keywords are exported into %s\n", len(rows), *output)
+		return nil
+	}
+	if f.format == FormatJSON {
+		return writeJSON(w, rowsJSON(rows))
+	}
+	data, err := csvutil.Marshal(rows)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Structure
+
+// categoryJSON is a category with its clusters in the JSON output, the root category has no name
+type categoryJSON struct {
+	Name       string             `json:"name,omitempty"`
+	Path       string             `json:"path,omitempty"`
+	Dir        string             `json:"dir"`
+	Clusters   []savedClusterJSON `json:"clusters"`
+	Categories []categoryJSON     `json:"categories,omitempty"`
+}
+
+// savedClusterJSON is a cluster of the applied operations which add rows
+type savedClusterJSON struct {
+	Name      string    `json:"name"`
+	File      string    `json:"file"`
+	Queries   []string  `json:"queries"`
+	Keywords  int       `json:"keywords"`
+	Frequency uint64    `json:"frequency"`
+	Rows      []rowJSON `json:"rows,omitempty"`
+}
+
+func runStructureCommand(args []string, w io.Writer) error {
+	f := newCommandFlags("structure")
+	keywords := f.Bool("keywords", false, "Print the keywords of every cluster")
+	project, err := f.load(args)
+	if err != nil {
+		return err
+	}
+
+	// Clusters of the applied operations by their categories in the history order
+	clusters := make(map[string][]*savedClusterJSON)
+	for _, op := range project.History.Operations[:project.History.CurrentStateIndex+1] {
+		if op.Operation != OperationAdd {
+			continue
+		}
+		var cluster *savedClusterJSON
+		for _, other := range clusters[op.Category] {
+			if other.Name == op.ClusterName() {
+				cluster = other
+			}
+		}
+		if cluster == nil {
+			cluster = &savedClusterJSON{Name: op.ClusterName(), File: op.File, Queries: []string{}}
+			clusters[op.Category] = append(clusters[op.Category], cluster)
+		}
+		query := op.Keyword
+		if op.Keywords != nil {
+			query = fmt.Sprintf("%s (keyword list)", op.Keyword)
+		}
+		cluster.Queries = append(cluster.Queries, query)
+		// Restore operations could take some rows back
+		cluster.Keywords += len(op.rows)
+		for _, row := range op.rows {
+			cluster.Frequency += uint64(row.Frequency)
+		}
+		if *keywords {
+			cluster.Rows = append(cluster.Rows, rowsJSON(op.rows)...)
+		}
+	}
+
+	var category func(path string, children []*Category) categoryJSON
+	category = func(path string, children []*Category) categoryJSON {
+		_, name := splitCategoryPath(path)
+		ret := categoryJSON{
+			Name:     name,
+			Path:     path,
+			Dir:      project.relativePath(project.CategoryDir(path)),
+			Clusters: []savedClusterJSON{},
+		}
+		for _, cluster := range clusters[path] {
+			ret.Clusters = append(ret.Clusters, *cluster)
+		}
+		for _, child := range children {
+			ret.Categories = append(ret.Categories, category(joinCategoryPath(path, child.Name), child.Children))
+		}
+		return ret
+	}
+	root := category("", project.Categories)
+
+	if f.format == FormatJSON {
+		return writeJSON(w, root)
+	}
+	writeCategory(w, root, "")
+	return nil
+}
+
+func writeCategory(w io.Writer, category categoryJSON, indent string) {
+	for _, cluster := range category.Clusters {
+		fmt.Fprintf(w, "%s%s\t%d\t%d\t%s\n", indent, cluster.Name, cluster.Keywords, cluster.Frequency, strings.Join(cluster.Queries, " | "))
+		for _, row := range cluster.Rows {
+			fmt.Fprintf(w, "%s    %s\t%d\t%d\n", indent, row.Keyword, row.Frequency, row.Exact)
+		}
+	}
+	for _, child := range category.Categories {
+		fmt.Fprintf(w, "%s%s/\n", indent, child.Name)
+		writeCategory(w, child, indent+"  ")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// ProjectConfig keeps the project settings between launches
+type ProjectConfig struct {
+	// Mapping of the source file headers which is used on import
+	Columns ColumnMapping `json:"columns"`
+	// Name of the normalizer which fills missing lemmas
+	Normalizer string `json:"normalizer,omitempty"`
+	// Format of the cluster files: csv or xlsx
+	ClusterFormat string `json:"cluster_format,omitempty"`
+	// Subclusters with less keywords are not shown in the tree
+	MinClusterSize uint `json:"min_cluster_size,omitempty"`
+	// Order of the tree nodes, one of clusterSorts
+	ClusterSort string `json:"cluster_sort,omitempty"`
+	// Metrics shown next to the tree node names, see treeColumns
+	TreeColumns []string `json:"tree_columns"`
+	// Words which are not shown as subclusters, keywords with them are kept
+	StopWords []string `json:"stop_words,omitempty"`
+	// Languages of the bundled stop words, see defaultStopWords
+	StopWordLanguages []string `json:"stop_word_languages"`
+	// Groups of words which are one cluster, the first word names the group
+	Synonyms [][]string `json:"synonyms,omitempty"`
+	// Whether cuts by a query cover the synonyms of its words: group or word
+	SynonymCuts string `json:"synonym_cuts,omitempty"`
+}
+
+func LoadProjectConfig(path string) (*ProjectConfig, error) {
+	config := ProjectConfig{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		config.setDefaults()
+		return &config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, jsonFileError(path, 0, data, err)
+	}
+	config.setDefaults()
+	for _, language := range config.StopWordLanguages {
+		if _, ok := defaultStopWords[language]; !ok {
+			return nil, &FileError{File: path, Err: fmt.Errorf("unknown stop words language %s, available: %s",
+				language, strings.Join(stopWordLanguages(), ", "))}
+		}
+	}
+	if err := validateSynonyms(config.Synonyms); err != nil {
+		return nil, &FileError{File: path, Err: err}
+	}
+	if config.SynonymCuts != SynonymCutsGroup && config.SynonymCuts != SynonymCutsWord {
+		return nil, &FileError{File: path, Err: fmt.Errorf("synonym_cuts must be group or word")}
+	}
+	return &config, nil
+}
+
+func (c *ProjectConfig) setDefaults() {
+	if c.MinClusterSize == 0 {
+		c.MinClusterSize = DefaultMinClusterSize
+	}
+	if c.ClusterSort == "" {
+		c.ClusterSort = ClusterSortCount
+	}
+	if c.TreeColumns == nil {
+		c.TreeColumns = defaultTreeColumns
+	}
+	if c.StopWordLanguages == nil {
+		c.StopWordLanguages = defaultStopWordLanguages
+	}
+	if c.SynonymCuts == "" {
+		c.SynonymCuts = SynonymCutsGroup
+	}
+}
+
+func (c *ProjectConfig) Save(path string) error {
+	data, err := c.encode()
+	if err != nil {
+		return err
+	}
+	return writeFileDataAtomic(path, data)
+}
+
+func (c *ProjectConfig) encode() ([]byte, error) {
+	return json.MarshalIndent(c, "", "  ")
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+)
+
+// FileError is an error of a project or history file.
+// Line and column start from 1, zero means the position is unknown.
+type FileError struct {
+	File   string
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *FileError) Error() string {
+	switch {
+	case e.Line > 0 && e.Column > 0:
+		return fmt.Sprintf("%s:%d:%d: %v", e.File, e.Line, e.Column, e.Err)
+	case e.Line > 0:
+		return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.File, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// jsonFileError finds the position of the JSON decoding error in the data.
+// Parameter line is the line of the data in the file, 0 if the data is the whole file.
+func jsonFileError(file string, line int, data []byte, err error) *FileError {
+	var offset int64
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	}
+	if offset <= 0 || offset > int64(len(data)) {
+		return &FileError{File: file, Line: line, Err: err}
+	}
+	before := data[:offset]
+	start := bytes.LastIndexByte(before, '\n') + 1
+	if line == 0 {
+		line = bytes.Count(before, []byte{'\n'}) + 1
+	}
+	return &FileError{File: file, Line: line, Column: utf8.RuneCount(before[start:]), Err: err}
+}
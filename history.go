@@ -2,10 +2,15 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"regexp"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 var lineRegexp = regexp.MustCompile(`((?P<operation>([-+/!]{1,2}))\s*(?P<text>.*))`)
@@ -14,113 +19,585 @@ const (
 	OperationRemove = iota
 	OperationSilentRemove
 	OperationAdd
+	// Returns the keywords of the clusters of the previous operations to the remaining ones
+	OperationRestore
 )
 
+// Names of the operation types in the history file
+var operationNames = map[int]string{
+	OperationRemove:       "remove",
+	OperationSilentRemove: "silent_remove",
+	OperationAdd:          "add",
+	OperationRestore:      "restore",
+}
+
+// Prefixes of the operation types in the text history and rules files
+var operationPrefixes = map[int]string{
+	OperationRemove:       "-",
+	OperationSilentRemove: "--",
+	OperationAdd:          "+",
+	OperationRestore:      "<",
+}
+
+// Version of the history file format. Version 1 is the plain text history.txt.
+const historyVersion = 2
+
+// The current state of the text history file when all operations are undone
+const historyInitialState = "!"
+
+// Name of the branch of a history file without branches
+const DefaultBranchName = "основная"
+
+// History is a tree of branches. A new operation after an undone one starts
+// a new branch, so the undone operations are kept in the previous branch.
+// Operations and CurrentStateIndex belong to the active branch.
 type History struct {
-	Operations        []*KeywordOperation
+	*HistoryBranch
+	// All branches including the active one
+	Branches []*HistoryBranch
+}
+
+// HistoryBranch is a full list of operations, branches share the operations before their fork
+type HistoryBranch struct {
+	Name       string
+	Operations []*KeywordOperation
+	// Index of the last applied operation, -1 if none is applied
 	CurrentStateIndex int
 }
 
+func NewHistory() *History {
+	branch := &HistoryBranch{Name: DefaultBranchName, CurrentStateIndex: -1}
+	return &History{HistoryBranch: branch, Branches: []*HistoryBranch{branch}}
+}
+
 type KeywordOperation struct {
+	// Query of the operation
 	Keyword   string
 	Operation int
+
+	// When the operation was made, zero for operations of the text history
+	Time time.Time
+	// Number and summed broad frequency of the rows which were cut
+	RowCount  int
+	Frequency uint64
+	// Cluster file relative to the project directory, empty for silent removal
+	File string
+	// Name of the cluster chosen by the user, the file is named by the query if it's empty.
+	// Several queries can be cut into one cluster.
+	Cluster string
+	// Path of the category of the cluster, its file is in the directory of the category.
+	// Only operations which add rows have a category, it's empty for the root.
+	Category string
+	Note     string
+	// Whether the words of the query matched their synonym groups
+	Synonyms bool
+	// Normalized synonym groups of the query words when the operation was made, empty if there were none.
+	// Nil for operations of history files which didn't save them.
+	SynonymGroups [][]string
+	// Keywords of the rows which were cut by a row operation of the keyword list.
+	// Such an operation cuts exactly these keywords, Keyword is only the name of its cluster.
+	Keywords []string
+
+	// Label of the saved cluster which is merged into the cluster of the operation, see SavedCluster.
+	// A merge operation takes the rows with its keywords from that cluster instead of the remaining rows.
+	Merge string
+
+	// Rows which were cut by the operation when it was applied, used by undo
+	rows []*Row
+	// Operations which the rows of a restore or merge operation were taken from, used by undo
+	sources map[*Row]*KeywordOperation
 }
 
-func LoadHistory(path string) *History {
-	history := History{}
-	file, err := os.OpenFile(path, os.O_RDONLY, 0777)
-	defer file.Close()
+// ClusterName returns the name of the cluster file of the operation
+func (op *KeywordOperation) ClusterName() string {
+	if op.Cluster != "" {
+		return op.Cluster
+	}
+	return op.Keyword
+}
+
+// clusterLabel returns the label of the saved cluster of the operation, see SavedCluster
+func (op *KeywordOperation) clusterLabel() string {
+	switch op.Operation {
+	case OperationAdd:
+		return joinCategoryPath(op.Category, op.ClusterName())
+	case OperationRemove:
+		return ProjectRemovedDir + "/" + op.ClusterName()
+	case OperationSilentRemove:
+		return operationPrefixes[OperationSilentRemove] + " " + op.ClusterName()
+	}
+	return ""
+}
+
+// sourceLabel returns the label of the saved cluster which the rows of the restore or merge operation
+// are taken from, it's the query of a restore operation. Other operations have no source.
+func (op *KeywordOperation) sourceLabel() string {
+	if op.Operation == OperationRestore {
+		return op.Keyword
+	}
+	return op.Merge
+}
+
+// setSourceLabel changes the label of the cluster which the rows are taken from
+func (op *KeywordOperation) setSourceLabel(label string) {
+	if op.Operation == OperationRestore {
+		op.Keyword = label
+	} else {
+		op.Merge = label
+	}
+}
+
+// setRows remembers the cut rows and updates their count and frequency
+func (op *KeywordOperation) setRows(rows []*Row) {
+	op.rows = rows
+	op.RowCount = len(rows)
+	op.Frequency = 0
+	for _, row := range rows {
+		op.Frequency += uint64(row.Frequency)
+	}
+}
+
+// takeRows takes the rows with the keywords of the restore or merge operation from the cluster
+// of its source label, see sourceLabel. Rows of other clusters with the same keywords are kept.
+// Operations of the cluster keep their count and frequency as they were cut.
+func (op *KeywordOperation) takeRows(previous []*KeywordOperation) {
+	keywords := stringsToMap(op.Keywords)
+	label := op.sourceLabel()
+	var rows []*Row
+	op.sources = make(map[*Row]*KeywordOperation)
+	for _, source := range previous {
+		if source.Operation == OperationRestore || source.clusterLabel() != label {
+			continue
+		}
+		// The rows can be shared with the cluster tree, so they are copied
+		kept := make([]*Row, 0, len(source.rows))
+		for _, row := range source.rows {
+			if _, ok := keywords[row.Keyword]; ok {
+				rows = append(rows, row)
+				op.sources[row] = source
+			} else {
+				kept = append(kept, row)
+			}
+		}
+		source.rows = kept
+	}
+	op.setRows(rows)
+}
+
+// returnRows gives the rows of the restore or merge operation back to the operations they were taken from
+func (op *KeywordOperation) returnRows() {
+	for _, row := range op.rows {
+		if source, ok := op.sources[row]; ok {
+			source.rows = append(source.rows, row)
+		}
+	}
+}
+
+// sourceOperations returns the operations which the rows of the restore or merge operation were taken from
+func (op *KeywordOperation) sourceOperations() []*KeywordOperation {
+	var sources []*KeywordOperation
+	for _, row := range op.rows {
+		if source, ok := op.sources[row]; ok && !containsOperation(sources, source) {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// containsOperation checks whether the operation is in the list
+func containsOperation(operations []*KeywordOperation, op *KeywordOperation) bool {
+	for _, other := range operations {
+		if other == op {
+			return true
+		}
+	}
+	return false
+}
+
+// historyRecord is a line of the history file: the header, an operation or a branch.
+// Operations form a tree by their parents, a branch is the path from its head to the root.
+type historyRecord struct {
+	Kind    string `json:"kind"`
+	Version int    `json:"version,omitempty"`
+
+	ID        int        `json:"id,omitempty"`
+	Parent    int        `json:"parent,omitempty"`
+	Operation string     `json:"operation,omitempty"`
+	Query     string     `json:"query,omitempty"`
+	Time      *time.Time `json:"time,omitempty"`
+	Rows      int        `json:"rows,omitempty"`
+	Frequency uint64     `json:"frequency,omitempty"`
+	File      string     `json:"file,omitempty"`
+	Cluster   string     `json:"cluster,omitempty"`
+	Category  string     `json:"category,omitempty"`
+	Note      string     `json:"note,omitempty"`
+	Synonyms  bool       `json:"synonyms,omitempty"`
+	Keywords  []string   `json:"keywords,omitempty"`
+	Merge     string     `json:"merge,omitempty"`
+	// Pointer, so an empty list of groups is saved
+	SynonymGroups *[][]string `json:"synonym_groups,omitempty"`
+
+	Name string `json:"name,omitempty"`
+	Head int    `json:"head,omitempty"`
+	// Number of the applied operations of the branch
+	Applied int  `json:"applied,omitempty"`
+	Active  bool `json:"active,omitempty"`
+}
+
+const (
+	historyRecordHeader    = "header"
+	historyRecordOperation = "operation"
+	historyRecordBranch    = "branch"
+)
+
+// LoadHistory reads the history file of any version, see loadTextHistory for the text format.
+// The file is JSON lines: the header with the format version, the operations and the branches.
+// A missing file is an empty history.
+func LoadHistory(path string) (*History, error) {
+	file, err := os.Open(path)
 	if os.IsNotExist(err) {
-		return &history
+		return NewHistory(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	start, _ := reader.Peek(1)
+	if len(start) == 0 || start[0] != '{' {
+		return loadTextHistory(reader, path)
+	}
+
+	history := &History{}
+	operations := make(map[int]*KeywordOperation)
+	parents := make(map[int]int)
+	// Lines of the operations for errors of the branches
+	lines := make(map[int]int)
+	scanner := bufio.NewScanner(reader)
+	// Row operations keep all their keywords in one line
+	scanner.Buffer(nil, 256*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var record historyRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, jsonFileError(path, line, scanner.Bytes(), err)
+		}
+		lineError := func(format string, args ...interface{}) error {
+			return &FileError{File: path, Line: line, Err: fmt.Errorf(format, args...)}
+		}
+		switch record.Kind {
+		case historyRecordHeader:
+			if record.Version > historyVersion {
+				return nil, lineError("history version %d is not supported, update the program", record.Version)
+			}
+		case historyRecordOperation:
+			op := &KeywordOperation{
+				Keyword:   record.Query,
+				Operation: -1,
+				RowCount:  record.Rows,
+				Frequency: record.Frequency,
+				File:      record.File,
+				Cluster:   record.Cluster,
+				Category:  record.Category,
+				Note:      record.Note,
+				Synonyms:  record.Synonyms,
+				Keywords:  record.Keywords,
+				Merge:     record.Merge,
+			}
+			if record.SynonymGroups != nil {
+				op.SynonymGroups = append([][]string{}, *record.SynonymGroups...)
+			}
+			for operation, name := range operationNames {
+				if name == record.Operation {
+					op.Operation = operation
+				}
+			}
+			if op.Operation == -1 {
+				return nil, lineError("unknown operation \"%s\", available: add, remove, silent_remove, restore", record.Operation)
+			}
+			if op.Operation == OperationRestore && len(op.Keywords) == 0 {
+				return nil, lineError("restore operation has no keywords")
+			}
+			if op.Merge != "" && (len(op.Keywords) == 0 || op.Operation != OperationAdd && op.Operation != OperationRemove) {
+				return nil, lineError("merge operation must add or remove keywords")
+			}
+			if err := validateQuery(record.Query); err != nil && op.Keywords == nil {
+				return nil, lineError("query \"%s\": %v", record.Query, err)
+			}
+			if record.Time != nil {
+				op.Time = *record.Time
+			}
+			operations[record.ID] = op
+			parents[record.ID] = record.Parent
+			lines[record.ID] = line
+		case historyRecordBranch:
+			branch := &HistoryBranch{Name: record.Name}
+			// A hand-edited file can have a parent cycle
+			visited := make(map[int]bool)
+			for id := record.Head; id != 0; id = parents[id] {
+				op, ok := operations[id]
+				if !ok {
+					return nil, lineError("operation %d is not found", id)
+				}
+				if visited[id] {
+					return nil, &FileError{File: path, Line: lines[id],
+						Err: fmt.Errorf("parents of operation %d make a cycle in branch \"%s\"", id, record.Name)}
+				}
+				visited[id] = true
+				branch.Operations = append([]*KeywordOperation{op}, branch.Operations...)
+			}
+			if record.Applied < 0 || record.Applied > len(branch.Operations) {
+				return nil, lineError("branch \"%s\" has %d operations, but %d are applied", record.Name, len(branch.Operations), record.Applied)
+			}
+			branch.CurrentStateIndex = record.Applied - 1
+			history.Branches = append(history.Branches, branch)
+			if record.Active || history.HistoryBranch == nil {
+				history.HistoryBranch = branch
+			}
+		default:
+			return nil, lineError("unknown record \"%s\"", record.Kind)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &FileError{File: path, Line: line + 1, Err: err}
 	}
-	check(err)
 
-	scanner := bufio.NewScanner(file)
+	if len(history.Branches) == 0 {
+		return NewHistory(), nil
+	}
+	return history, nil
+}
+
+// validateQuery checks the syntax of the query of an operation
+func validateQuery(query string) error {
+	if isPlainQuery(query) {
+		return nil
+	}
+	_, err := ParseQuery(query, nil)
+	return err
+}
+
+// loadTextHistory reads the history of the first version. It starts with the current operation of the active branch:
+//
+//	= <current keyword>
+//	@ <active branch name>
+//	+ <keyword>
+//	- <keyword>
+//	@ <other branch name>
+//	= <current keyword of the branch>
+//	-- <keyword>
+//
+// Branch lines are optional, a file without them is a single branch.
+// Parameter path is only used in errors.
+func loadTextHistory(reader io.Reader, path string) (*History, error) {
+	history := NewHistory()
+	scanner := bufio.NewScanner(reader)
 
 	// Load current operation
 	scanner.Scan()
 	firstLine := strings.TrimSpace(scanner.Text())
 	if firstLine == "" {
-		return &history
+		return history, scanner.Err()
+	}
+	if !strings.HasPrefix(firstLine, "=") {
+		return nil, &FileError{File: path, Line: 1, Column: 1, Err: fmt.Errorf("the first line must be \"= <current keyword>\"")}
 	}
-	currentOperation := strings.TrimSpace(firstLine[1:])
 
-	for scanner.Scan() {
-		text := strings.TrimSpace(scanner.Text())
+	branch := history.HistoryBranch
+	currentOperations := map[*HistoryBranch]string{branch: strings.TrimSpace(firstLine[1:])}
+	named := false
+	for line := 2; scanner.Scan(); line++ {
+		raw := scanner.Text()
+		text := strings.TrimSpace(raw)
 		if text == "" {
 			continue
 		}
-		match := lineRegexp.FindStringSubmatch(text)
-		paramsMap := make(map[string]string)
-		for i, name := range lineRegexp.SubexpNames() {
-			if i > 0 && i <= len(match) {
-				paramsMap[name] = strings.TrimSpace(match[i])
+		// Column of the first character of the text in the raw line
+		column := utf8.RuneCountInString(raw[:strings.Index(raw, text)]) + 1
+		if strings.HasPrefix(text, "@") {
+			name := strings.TrimSpace(text[1:])
+			if branch == history.HistoryBranch && len(branch.Operations) == 0 && !named {
+				branch.Name = name
+				named = true
+				continue
 			}
+			branch = &HistoryBranch{Name: name}
+			history.Branches = append(history.Branches, branch)
+			currentOperations[branch] = ""
+			continue
+		}
+		if strings.HasPrefix(text, "=") {
+			currentOperations[branch] = strings.TrimSpace(text[1:])
+			continue
 		}
 
-		operation, ok := paramsMap["operation"]
-		if !ok {
-			panic("invalid operation: " + text)
+		op, offset, err := parseTextOperation(text)
+		if err != nil {
+			return nil, &FileError{File: path, Line: line, Column: column + offset, Err: err}
 		}
+		branch.Operations = append(branch.Operations, op)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &FileError{File: path, Err: err}
+	}
 
-		operationType := -1
-		switch operation {
-		case "-":
-			operationType = OperationRemove
-		case "--":
-			operationType = OperationSilentRemove
-		case "+":
-			operationType = OperationAdd
+	for i, branch := range history.Branches {
+		branch.Operations = removeDuplicates(branch.Operations)
+		branch.setCurrentOperation(currentOperations[branch])
+		// Operations before the fork are shared with the previous branches
+		for _, previous := range history.Branches[:i] {
+			for j := 0; j < len(branch.Operations) && j < len(previous.Operations); j++ {
+				a, b := branch.Operations[j], previous.Operations[j]
+				if a.Keyword != b.Keyword || a.Operation != b.Operation {
+					break
+				}
+				branch.Operations[j] = b
+			}
 		}
+	}
+
+	return history, nil
+}
 
-		text, ok = paramsMap["text"]
-		if !ok {
-			panic("invalid operation: " + text)
+// parseTextOperation parses the operation line of the text history: the prefix and the query.
+// On error it also returns the offset of the wrong character in runes.
+func parseTextOperation(text string) (*KeywordOperation, int, error) {
+	match := lineRegexp.FindStringSubmatch(text)
+	if match == nil {
+		return nil, 0, fmt.Errorf("unknown operation \"%s\", lines must start with +, - or --", text)
+	}
+	paramsMap := make(map[string]string)
+	for i, name := range lineRegexp.SubexpNames() {
+		if i > 0 && i <= len(match) {
+			paramsMap[name] = strings.TrimSpace(match[i])
 		}
+	}
 
-		history.AddOperation(text, operationType)
+	operationType := -1
+	operation := paramsMap["operation"]
+	for t, prefix := range operationPrefixes {
+		if prefix == operation {
+			operationType = t
+		}
 	}
-	history.Operations = removeDuplicates(history.Operations)
+	if operationType == -1 {
+		return nil, 0, fmt.Errorf("unknown operation prefix \"%s\", available: +, - and --", operation)
+	}
+
+	keyword := paramsMap["text"]
+	// Offset of the query in the line
+	offset := utf8.RuneCountInString(text[:strings.Index(text, keyword)])
+	if keyword == "" {
+		return nil, offset, fmt.Errorf("operation \"%s\" has no query", operation)
+	}
+	if err := validateQuery(keyword); err != nil {
+		if queryErr, ok := err.(*QueryError); ok {
+			return nil, offset + queryErr.Position, fmt.Errorf("query \"%s\": %s", keyword, queryErr.Message)
+		}
+		return nil, offset, err
+	}
+	return &KeywordOperation{Keyword: keyword, Operation: operationType}, 0, nil
+}
+
+// setCurrentOperation finds the current state by the keyword of the file
+func (b *HistoryBranch) setCurrentOperation(currentOperation string) {
 	if currentOperation == "" {
-		history.CurrentStateIndex = len(history.Operations) - 1
+		b.CurrentStateIndex = len(b.Operations) - 1
+	} else if currentOperation == historyInitialState {
+		b.CurrentStateIndex = -1
 	} else {
-		for i, op := range history.Operations {
+		for i, op := range b.Operations {
 			if currentOperation == op.Keyword {
-				history.CurrentStateIndex = i
+				b.CurrentStateIndex = i
 				break
 			}
 		}
 	}
+}
 
-	return &history
+// Save writes the history file atomically, an empty history is not written
+func (h *History) Save(path string) error {
+	data, err := h.encode()
+	if err != nil || data == nil {
+		return err
+	}
+	return writeFileDataAtomic(path, data)
 }
 
-func (h *History) Save(path string) {
-	if len(h.Operations) == 0 {
-		return
+// encode returns the history as JSON lines, nil for an empty history
+func (h *History) encode() ([]byte, error) {
+	if len(h.Operations) == 0 && len(h.Branches) == 1 {
+		return nil, nil
 	}
 
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0777)
-	defer file.Close()
-	check(err)
-	writer := bufio.NewWriter(file)
-	fmt.Fprintf(writer, "= %s\n\n", h.Operations[h.CurrentStateIndex].Keyword)
-	for _, operation := range h.Operations {
-		var prefix string
-		if operation.Operation == OperationRemove {
-			prefix = "-"
-		} else if operation.Operation == OperationSilentRemove {
-			prefix = "--"
-		} else if operation.Operation == OperationAdd {
-			prefix = "+"
-		}
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(historyRecord{Kind: historyRecordHeader, Version: historyVersion}); err != nil {
+		return nil, err
+	}
 
-		fmt.Fprintf(writer, "%s %s\n", prefix, operation.Keyword)
+	// Operations shared by branches are written once
+	ids := make(map[*KeywordOperation]int)
+	for _, branch := range h.Branches {
+		parent := 0
+		for _, op := range branch.Operations {
+			id, ok := ids[op]
+			if !ok {
+				id = len(ids) + 1
+				ids[op] = id
+				record := historyRecord{
+					Kind:      historyRecordOperation,
+					ID:        id,
+					Parent:    parent,
+					Operation: operationNames[op.Operation],
+					Query:     op.Keyword,
+					Rows:      op.RowCount,
+					Frequency: op.Frequency,
+					File:      op.File,
+					Cluster:   op.Cluster,
+					Category:  op.Category,
+					Note:      op.Note,
+					Synonyms:  op.Synonyms,
+					Keywords:  op.Keywords,
+					Merge:     op.Merge,
+				}
+				if op.Synonyms && op.SynonymGroups != nil {
+					record.SynonymGroups = &op.SynonymGroups
+				}
+				if !op.Time.IsZero() {
+					record.Time = &op.Time
+				}
+				if err := encoder.Encode(record); err != nil {
+					return nil, err
+				}
+			}
+			parent = id
+		}
+	}
+	for _, branch := range h.Branches {
+		record := historyRecord{
+			Kind:    historyRecordBranch,
+			Name:    branch.Name,
+			Applied: branch.CurrentStateIndex + 1,
+			Active:  branch == h.HistoryBranch,
+		}
+		if len(branch.Operations) > 0 {
+			record.Head = ids[branch.Operations[len(branch.Operations)-1]]
+		}
+		if err := encoder.Encode(record); err != nil {
+			return nil, err
+		}
 	}
-	writer.Flush()
+	return buffer.Bytes(), nil
 }
 
-func (h *History) AddOperation(keyword string, operation int) {
+func (h *History) AddOperation(keyword string, operation int) *KeywordOperation {
 	op := KeywordOperation{
 		Keyword:   keyword,
 		Operation: operation,
@@ -128,10 +605,62 @@ func (h *History) AddOperation(keyword string, operation int) {
 	if len(h.Operations) == 0 || h.CurrentStateIndex == len(h.Operations)-1 {
 		h.Operations = append(h.Operations, &op)
 	} else {
-		operations := h.Operations[:h.CurrentStateIndex+1]
-		h.Operations = append(operations, &op)
+		// The undone operations are kept in the current branch
+		operations := make([]*KeywordOperation, h.CurrentStateIndex+1, h.CurrentStateIndex+2)
+		copy(operations, h.Operations)
+		branch := &HistoryBranch{Name: h.newBranchName(), Operations: append(operations, &op)}
+		h.Branches = append(h.Branches, branch)
+		h.HistoryBranch = branch
 	}
 	h.CurrentStateIndex = len(h.Operations) - 1
+	return &op
+}
+
+func (h *History) newBranchName() string {
+	for i := len(h.Branches) + 1; ; i++ {
+		name := fmt.Sprintf("ветка %d", i)
+		if h.FindBranch(name) == nil {
+			return name
+		}
+	}
+}
+
+// FindBranch returns the branch by its name or nil
+func (h *History) FindBranch(name string) *HistoryBranch {
+	for _, branch := range h.Branches {
+		if branch.Name == name {
+			return branch
+		}
+	}
+	return nil
+}
+
+// CommonOperations returns the number of operations the branches share before their fork
+func CommonOperations(a, b *HistoryBranch) int {
+	i := 0
+	for i < len(a.Operations) && i < len(b.Operations) && a.Operations[i] == b.Operations[i] {
+		i++
+	}
+	return i
+}
+
+// Undo steps the current state back and returns the reverted operation, nil if nothing is applied
+func (h *History) Undo() *KeywordOperation {
+	if h.CurrentStateIndex < 0 {
+		return nil
+	}
+	op := h.Operations[h.CurrentStateIndex]
+	h.CurrentStateIndex--
+	return op
+}
+
+// Redo steps the current state forward and returns the operation to apply, nil if all are applied
+func (h *History) Redo() *KeywordOperation {
+	if h.CurrentStateIndex >= len(h.Operations)-1 {
+		return nil
+	}
+	h.CurrentStateIndex++
+	return h.Operations[h.CurrentStateIndex]
 }
 
 func (h *History) SetOperation(operation *KeywordOperation) {
@@ -143,12 +672,18 @@ func (h *History) SetOperation(operation *KeywordOperation) {
 	}
 }
 
+// removeDuplicates drops repeated operations of the same type and keyword
 func removeDuplicates(operations []*KeywordOperation) []*KeywordOperation {
-	m := make(map[string]struct{}, len(operations))
+	type operationKey struct {
+		keyword   string
+		operation int
+	}
+	m := make(map[operationKey]struct{}, len(operations))
 	var clean []*KeywordOperation
 	for i, op := range operations {
-		if _, ok := m[op.Keyword]; !ok {
-			m[op.Keyword] = struct{}{}
+		key := operationKey{op.Keyword, op.Operation}
+		if _, ok := m[key]; !ok {
+			m[key] = struct{}{}
 			clean = append(clean, operations[i])
 		}
 	}
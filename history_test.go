@@ -0,0 +1,342 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestProject creates a project of the keywords in a temporary directory.
+// Frequencies of the keywords are their numbers starting from 1.
+func newTestProject(t *testing.T, keywords ...string) *Project {
+	dir, err := ioutil.TempDir("", "project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := "Keyword,Volume\n"
+	for i, keyword := range keywords {
+		source += fmt.Sprintf("%s,%d\n", keyword, i+1)
+	}
+	file := filepath.Join(dir, "keywords.csv")
+	if err := ioutil.WriteFile(file, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := CreateProject(filepath.Join(dir, "project"), []string{file}, SourceOptions{}, MergeOptions{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// closeTestProject closes the project and removes its directory
+func closeTestProject(t *testing.T, p *Project) {
+	if err := p.Close(); err != nil {
+		t.Error(err)
+	}
+	os.RemoveAll(filepath.Dir(p.Paths.Dir))
+}
+
+// cutQuery cuts the rows of the query as the cluster tree does
+func cutQuery(t *testing.T, p *Project, query string, operation int) *KeywordOperation {
+	rows, err := p.Select(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op, err := p.ProcessOperation(query, rows, operation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return op
+}
+
+// remainingKeywords returns the keywords of the remaining rows in their order
+func remainingKeywords(p *Project) string {
+	keywords := make([]string, len(p.Rows))
+	for i, row := range p.Rows {
+		keywords[i] = row.Keyword
+	}
+	return strings.Join(keywords, ", ")
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestProjectUndoRedo(t *testing.T) {
+	p := newTestProject(t, "buy shoes", "cheap shoes", "red boots", "blue hat")
+	defer closeTestProject(t, p)
+	cutQuery(t, p, "shoes", OperationAdd)
+	cutQuery(t, p, "boots", OperationRemove)
+	cutQuery(t, p, "hat", OperationSilentRemove)
+	shoes := p.ClusterPath("", "shoes", OperationAdd)
+	boots := p.ClusterPath("", "boots", OperationRemove)
+
+	tests := []struct {
+		action    string
+		operation string
+		current   int
+		remaining string
+		files     []bool // whether the files of shoes and boots exist
+	}{
+		{"undo", "hat", 1, "blue hat", []bool{true, true}},
+		{"undo", "boots", 0, "red boots, blue hat", []bool{true, false}},
+		{"redo", "boots", 1, "blue hat", []bool{true, true}},
+		{"undo", "boots", 0, "red boots, blue hat", []bool{true, false}},
+		{"undo", "shoes", -1, "buy shoes, cheap shoes, red boots, blue hat", []bool{false, false}},
+		{"undo", "", -1, "buy shoes, cheap shoes, red boots, blue hat", []bool{false, false}},
+		{"redo", "shoes", 0, "red boots, blue hat", []bool{true, false}},
+		{"redo", "boots", 1, "blue hat", []bool{true, true}},
+		{"redo", "hat", 2, "", []bool{true, true}},
+		{"redo", "", 2, "", []bool{true, true}},
+	}
+	for i, test := range tests {
+		var op *KeywordOperation
+		if test.action == "undo" {
+			op = p.Undo()
+		} else {
+			var err error
+			if op, err = p.Redo(); err != nil {
+				t.Fatalf("step %d: %v", i+1, err)
+			}
+		}
+		var operation string
+		if op != nil {
+			operation = op.Keyword
+		}
+		if operation != test.operation || p.History.CurrentStateIndex != test.current {
+			t.Errorf("step %d: %s of \"%s\" to %d, want \"%s\" to %d", i+1, test.action, operation,
+				p.History.CurrentStateIndex, test.operation, test.current)
+		}
+		if remaining := remainingKeywords(p); remaining != test.remaining {
+			t.Errorf("step %d: remaining %q, want %q", i+1, remaining, test.remaining)
+		}
+		if err := p.writer.Wait(); err != nil {
+			t.Fatal(err)
+		}
+		if fileExists(shoes) != test.files[0] || fileExists(boots) != test.files[1] {
+			t.Errorf("step %d: files %v %v, want %v", i+1, fileExists(shoes), fileExists(boots), test.files)
+		}
+	}
+}
+
+func TestHistoryUndoRedoIsSaved(t *testing.T) {
+	p := newTestProject(t, "buy shoes", "red boots")
+	defer closeTestProject(t, p)
+	cutQuery(t, p, "shoes", OperationAdd)
+	cutQuery(t, p, "boots", OperationAdd)
+	p.Undo()
+	p.Save()
+	if err := p.writer.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := LoadHistory(p.Paths.HistoryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The undone operation is kept, so it can be redone after the project is opened again
+	if len(history.Operations) != 2 || history.CurrentStateIndex != 0 {
+		t.Fatalf("history has %d operations with %d applied, want 2 with 1", len(history.Operations), history.CurrentStateIndex+1)
+	}
+}
+
+func TestSwitchBranch(t *testing.T) {
+	p := newTestProject(t, "buy shoes", "cheap shoes", "red boots", "blue hat", "green hat")
+	defer closeTestProject(t, p)
+	cutQuery(t, p, "shoes", OperationAdd)
+	cutQuery(t, p, "boots", OperationAdd)
+	// A cut after the undo keeps the undone operation in the first branch
+	p.Undo()
+	cutQuery(t, p, "blue", OperationAdd)
+	cutQuery(t, p, "green", OperationAdd)
+	p.Undo()
+	first, second := p.History.Branches[0], p.History.Branches[1]
+	if len(p.History.Branches) != 2 || p.History.Name != "ветка 2" || CommonOperations(first, second) != 1 {
+		t.Fatalf("branches %d, active %s, common operations %d", len(p.History.Branches), p.History.Name,
+			CommonOperations(first, second))
+	}
+	boots := p.ClusterPath("", "boots", OperationAdd)
+	blue := p.ClusterPath("", "blue", OperationAdd)
+
+	tests := []struct {
+		branch    *HistoryBranch
+		current   int
+		remaining string
+		files     []bool // whether the files of boots and blue exist
+	}{
+		// The first branch is where it was left, its last operation is undone
+		{first, 0, "red boots, blue hat, green hat", []bool{false, false}},
+		{second, 1, "red boots, green hat", []bool{false, true}},
+		{second, 1, "red boots, green hat", []bool{false, true}},
+		{first, 0, "red boots, blue hat, green hat", []bool{false, false}},
+	}
+	for i, test := range tests {
+		if err := p.SwitchBranch(test.branch); err != nil {
+			t.Fatal(err)
+		}
+		if p.History.HistoryBranch != test.branch || p.History.CurrentStateIndex != test.current {
+			t.Errorf("step %d: branch %s at %d, want %s at %d", i+1, p.History.Name, p.History.CurrentStateIndex,
+				test.branch.Name, test.current)
+		}
+		if remaining := remainingKeywords(p); remaining != test.remaining {
+			t.Errorf("step %d: remaining %q, want %q", i+1, remaining, test.remaining)
+		}
+		if err := p.writer.Wait(); err != nil {
+			t.Fatal(err)
+		}
+		if fileExists(boots) != test.files[0] || fileExists(blue) != test.files[1] {
+			t.Errorf("step %d: files %v %v, want %v", i+1, fileExists(boots), fileExists(blue), test.files)
+		}
+	}
+
+	if _, err := p.Redo(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.writer.Wait(); err != nil || !fileExists(boots) {
+		t.Fatalf("file of the redone operation is not written: %v", err)
+	}
+
+	// Every branch keeps its own state, the shared operations are saved once
+	p.Save()
+	if err := p.writer.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	history, err := LoadHistory(p.Paths.HistoryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Branches) != 2 || history.Name != first.Name || history.Branches[1].CurrentStateIndex != 1 ||
+		history.Branches[0].Operations[0] != history.Branches[1].Operations[0] {
+		t.Fatalf("branches are not loaded as saved")
+	}
+}
+
+func TestLoadTextHistory(t *testing.T) {
+	tests := []struct {
+		text     string
+		branches []string // names and applied operations of the branches, the active one first
+	}{
+		{"", []string{"основная 0/0"}},
+		{"= \n+ shoes\n- boots\n", []string{"основная 2/2"}},
+		{"= boots\n+ shoes\n- boots\n-- hat\n", []string{"основная 2/3"}},
+		{"= shoes\n@ основная\n+ shoes\n- boots\n@ ветка 2\n= cheap\n+ shoes\n+ cheap\n",
+			[]string{"основная 1/2", "ветка 2 2/2"}},
+		{"= cheap\n@ ветка 2\n+ shoes\n+ cheap\n@ основная\n= \n+ shoes\n",
+			[]string{"ветка 2 2/2", "основная 1/1"}},
+	}
+	for _, test := range tests {
+		history, err := loadTextHistory(strings.NewReader(test.text), "history.txt")
+		if err != nil {
+			t.Errorf("%q: %v", test.text, err)
+			continue
+		}
+		branches := []string{fmt.Sprintf("%s %d/%d", history.Name, history.CurrentStateIndex+1, len(history.Operations))}
+		for _, branch := range history.Branches {
+			if branch != history.HistoryBranch {
+				branches = append(branches, fmt.Sprintf("%s %d/%d", branch.Name, branch.CurrentStateIndex+1, len(branch.Operations)))
+			}
+		}
+		if strings.Join(branches, ", ") != strings.Join(test.branches, ", ") {
+			t.Errorf("%q: branches %v, want %v", test.text, branches, test.branches)
+		}
+	}
+}
+
+func TestMigrateTextHistory(t *testing.T) {
+	p := newTestProject(t, "buy shoes", "cheap shoes", "red boots")
+	text := "= boots\n@ основная\n+ shoes\n- boots\n@ ветка 2\n= shoes\n+ shoes\n"
+	if err := ioutil.WriteFile(p.Paths.LegacyHistoryFile, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(p.Paths.HistoryFile)
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := LoadProject(p.Paths.Dir, SourceOptions{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fileExists(p.Paths.HistoryFile) || fileExists(p.Paths.LegacyHistoryFile) || !fileExists(p.Paths.LegacyHistoryFile+".bak") {
+		t.Fatal("history file is not migrated")
+	}
+	history, err := LoadHistory(p.Paths.HistoryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Branches) != 2 || history.CurrentStateIndex != 1 || history.Branches[1].Operations[0] != history.Operations[0] {
+		t.Fatal("branches are not migrated")
+	}
+	if file := history.Operations[1].File; file != "clusters/removed/boots.csv" {
+		t.Errorf("file of the operation is %s", file)
+	}
+	if remaining := remainingKeywords(p); remaining != "" {
+		t.Errorf("remaining %q after the migrated history is applied", remaining)
+	}
+	closeTestProject(t, p)
+}
+
+func TestLoadHistoryErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		line  int
+		err   string
+	}{
+		{"cycle", []string{
+			`{"kind":"operation","id":1,"parent":2,"operation":"add","query":"a"}`,
+			`{"kind":"operation","id":2,"parent":1,"operation":"add","query":"b"}`,
+			`{"kind":"branch","name":"основная","head":2,"applied":1}`,
+		}, 2, "make a cycle"},
+		{"self parent", []string{
+			`{"kind":"operation","id":1,"parent":1,"operation":"add","query":"a"}`,
+			`{"kind":"branch","name":"основная","head":1,"applied":1}`,
+		}, 1, "make a cycle"},
+		{"missing parent", []string{
+			`{"kind":"operation","id":2,"parent":1,"operation":"add","query":"a"}`,
+			`{"kind":"branch","name":"основная","head":2,"applied":1}`,
+		}, 2, "operation 1 is not found"},
+		{"applied", []string{
+			`{"kind":"operation","id":1,"operation":"add","query":"a"}`,
+			`{"kind":"branch","name":"основная","head":1,"applied":2}`,
+		}, 2, "2 are applied"},
+		{"operation", []string{
+			`{"kind":"operation","id":1,"operation":"cut","query":"a"}`,
+		}, 1, "unknown operation"},
+		{"query", []string{
+			`{"kind":"operation","id":1,"operation":"add","query":"(a"}`,
+		}, 1, "query"},
+		{"record", []string{
+			`{"kind":"note"}`,
+		}, 1, "unknown record"},
+		{"version", []string{
+			`{"kind":"header","version":1000}`,
+		}, 1, "not supported"},
+	}
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ProjectHistoryFile)
+	for _, test := range tests {
+		data := strings.Join(append([]string{`{"kind":"header","version":1}`}, test.lines...), "\n")
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadHistory(path)
+		fileErr, ok := err.(*FileError)
+		if !ok {
+			t.Errorf("%s: error %v, want a file error", test.name, err)
+			continue
+		}
+		// The header is the first line
+		if fileErr.Line != test.line+1 || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: %v, want line %d and %q", test.name, err, test.line+1, test.err)
+		}
+	}
+}
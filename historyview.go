@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+	"strings"
+)
+
+// branchesList shows the history branches. Rename and compare dialogs are opened as pages.
+// Parameter done is called with the branch to switch to or nil.
+func branchesList(app *App, pages *tview.Pages, done func(branch *HistoryBranch)) *SimpleList {
+	history := app.State.Project.History
+	list := NewSimpleList()
+	list.SetBorder(true).
+		SetTitle("Ветки истории: Enter — переключить, R — переименовать, C — сравнить с активной").
+		SetBorderPadding(0, 0, 1, 1)
+
+	fill := func() {
+		current := list.GetCurrentItem()
+		list.Clear()
+		for _, branch := range history.Branches {
+			branch := branch
+			var pointer string
+			if branch == history.HistoryBranch {
+				pointer = " <-- активная"
+			}
+			list.AddItem(fmt.Sprintf("[yellow]%s[white] — операций: %d, применено: %d, общих с активной: %d%s",
+				branch.Name, len(branch.Operations), branch.CurrentStateIndex+1,
+				CommonOperations(branch, history.HistoryBranch), pointer), func() {
+				done(branch)
+			})
+		}
+		list.SetCurrentItem(current)
+	}
+	fill()
+
+	closeDialog := func() {
+		pages.RemovePage("BranchDialog")
+		pages.SwitchToPage("Branches")
+		app.UI.SetFocus(list)
+	}
+
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		branch := history.Branches[list.GetCurrentItem()]
+		switch {
+		case event.Key() == tcell.KeyEscape:
+			done(nil)
+		case event.Rune() == 'r' || event.Rune() == 'к':
+			input := textInput("Переименование ветки", " Название: ", branch.Name, func(name string, ok bool) {
+				name = strings.TrimSpace(name)
+				if ok && name != "" && (name == branch.Name || history.FindBranch(name) == nil) {
+					branch.Name = name
+					app.State.Project.SaveHistory()
+					fill()
+				}
+				closeDialog()
+			})
+			pages.AddAndSwitchToPage("BranchDialog", input, true)
+			return nil
+		case event.Rune() == 'c' || event.Rune() == 'с':
+			comparison := branchComparison(history.HistoryBranch, branch)
+			comparison.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+				if event.Key() == tcell.KeyEscape {
+					closeDialog()
+				}
+				return event
+			})
+			pages.AddAndSwitchToPage("BranchDialog", comparison, true)
+			return nil
+		}
+		return event
+	})
+	return list
+}
+
+// textInput asks a line of text, ok is false if the input is canceled
+func textInput(title, label, text string, done func(text string, ok bool)) *tview.InputField {
+	input := tview.NewInputField()
+	input.SetBorder(true).SetTitle(title)
+	input.SetLabel(label).SetText(text)
+	input.SetFieldBackgroundColor(0x586E75)
+	input.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEnter {
+			done(input.GetText(), true)
+		} else if key == tcell.KeyEscape {
+			done("", false)
+		}
+	})
+	return input
+}
+
+// operationTarget returns the query of the operation with its cluster if the cluster is named
+func operationTarget(op *KeywordOperation) string {
+	if op.Cluster != "" {
+		return op.Keyword + " → " + op.Cluster
+	}
+	return op.Keyword
+}
+
+// clusterInput asks the name of a cluster. Names of the existing clusters of the current category are completed.
+func clusterInput(app *App, title, text string, done func(name string, ok bool)) *tview.InputField {
+	input := textInput(title, " Кластер: ", text, done)
+	names := app.State.Project.ClusterNames(app.State.Project.Category)
+	input.SetAutocompleteFunc(func(text string) []string {
+		text = strings.ToLower(strings.TrimSpace(text))
+		if text == "" {
+			return nil
+		}
+		var entries []string
+		for _, name := range names {
+			if strings.Contains(strings.ToLower(name), text) {
+				entries = append(entries, name)
+			}
+		}
+		return entries
+	})
+	return input
+}
+
+// operationDetails describes the rows, time, file and note of the operation for the history lists
+func operationDetails(op *KeywordOperation) string {
+	details := []string{fmt.Sprintf("запросов: %d", op.RowCount), fmt.Sprintf("частотность: %d", op.Frequency)}
+	if !op.Time.IsZero() {
+		details = append(details, op.Time.Local().Format("02.01.2006 15:04"))
+	}
+	if op.File != "" {
+		details = append(details, op.File)
+	}
+	if op.Keywords != nil {
+		details = append(details, "по списку запросов")
+	}
+	text := " [gray]" + tview.Escape(strings.Join(details, " · "))
+	if op.Note != "" {
+		text += " [yellow]" + tview.Escape(op.Note)
+	}
+	return text
+}
+
+// branchComparison lists the shared operations count and the operations of every branch after the fork
+func branchComparison(a, b *HistoryBranch) *tview.TextView {
+	view := tview.NewTextView()
+	view.SetDynamicColors(true).SetBorder(true).SetTitle("Сравнение веток").SetBorderPadding(0, 0, 1, 1)
+
+	common := CommonOperations(a, b)
+	fmt.Fprintf(view, "Общих операций: %d\n", common)
+	for _, branch := range []*HistoryBranch{a, b} {
+		fmt.Fprintf(view, "\nТолько в ветке [yellow]%s[white]:\n", tview.Escape(branch.Name))
+		if len(branch.Operations) == common {
+			fmt.Fprintln(view, "  нет операций")
+		}
+		for i, op := range branch.Operations[common:] {
+			var pointer string
+			if common+i == branch.CurrentStateIndex {
+				pointer = " <-- текущий"
+			}
+			fmt.Fprintf(view, "  %v. %s[white]%s%s\n", common+i+1, operationText(op), pointer, operationDetails(op))
+		}
+	}
+	return view
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// WordIndex is an inverted index of row lemmas.
+// Every word gets an integer id and a posting list of the row positions which contain it.
+// Rows are never deleted from the index, removed rows are only marked in a bitmap,
+// so the index is updated incrementally by cuts, undo and history restores.
+type WordIndex struct {
+	rows      []*Row
+	positions map[*Row]int32
+	// Word ids of every row
+	rowWords [][]uint32
+
+	wordIDs  map[string]uint32
+	words    []string
+	postings [][]int32
+
+	removed []uint64
+	live    int
+
+	// Words which are skipped by GenerateSubClusters
+	stopWords map[string]struct{}
+	// Words which are merged into one subcluster by GenerateSubClusters
+	synonyms Synonyms
+}
+
+func NewWordIndex(rows []*Row) *WordIndex {
+	index := &WordIndex{
+		positions: make(map[*Row]int32, len(rows)),
+		wordIDs:   make(map[string]uint32),
+	}
+	index.Add(rows)
+	return index
+}
+
+// Add appends the rows which are not indexed yet
+func (x *WordIndex) Add(rows []*Row) {
+	for _, row := range rows {
+		if _, ok := x.positions[row]; ok {
+			continue
+		}
+		position := int32(len(x.rows))
+		x.rows = append(x.rows, row)
+		x.positions[row] = position
+
+		fields := strings.Fields(row.NormalizedKeyword)
+		ids := make([]uint32, 0, len(fields))
+		for _, word := range fields {
+			id, ok := x.wordIDs[word]
+			if !ok {
+				id = uint32(len(x.words))
+				x.wordIDs[word] = id
+				x.words = append(x.words, word)
+				x.postings = append(x.postings, nil)
+			}
+			postings := x.postings[id]
+			// A word repeated in the lemma is indexed once
+			if len(postings) == 0 || postings[len(postings)-1] != position {
+				x.postings[id] = append(postings, position)
+			}
+			ids = append(ids, id)
+		}
+		x.rowWords = append(x.rowWords, ids)
+		if int(position)/64 >= len(x.removed) {
+			x.removed = append(x.removed, 0)
+		}
+		x.live++
+	}
+}
+
+// Remove marks the rows as removed
+func (x *WordIndex) Remove(rows []*Row) {
+	for _, row := range rows {
+		position, ok := x.positions[row]
+		if !ok || x.isRemoved(position) {
+			continue
+		}
+		x.removed[position/64] |= 1 << uint(position%64)
+		x.live--
+	}
+}
+
+// Restore returns the removed rows back
+func (x *WordIndex) Restore(rows []*Row) {
+	for _, row := range rows {
+		position, ok := x.positions[row]
+		if !ok || !x.isRemoved(position) {
+			continue
+		}
+		x.removed[position/64] &^= 1 << uint(position%64)
+		x.live++
+	}
+}
+
+// Reset restores all rows
+func (x *WordIndex) Reset() {
+	for i := range x.removed {
+		x.removed[i] = 0
+	}
+	x.live = len(x.rows)
+}
+
+func (x *WordIndex) isRemoved(position int32) bool {
+	return x.removed[position/64]&(1<<uint(position%64)) != 0
+}
+
+// Has checks whether the row is indexed and not removed
+func (x *WordIndex) Has(row *Row) bool {
+	position, ok := x.positions[row]
+	return ok && !x.isRemoved(position)
+}
+
+// Len returns the number of rows which are not removed
+func (x *WordIndex) Len() int {
+	return x.live
+}
+
+// Rows returns the rows which are not removed in the order they were added
+func (x *WordIndex) Rows() []*Row {
+	rows := make([]*Row, 0, x.live)
+	for position, row := range x.rows {
+		if !x.isRemoved(int32(position)) {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// SetStopWords sets the words which are not used as subclusters
+func (x *WordIndex) SetStopWords(words map[string]struct{}) {
+	x.stopWords = words
+}
+
+// IsStopWord checks whether the word is not used as a subcluster
+func (x *WordIndex) IsStopWord(word string) bool {
+	_, ok := x.stopWords[word]
+	return ok
+}
+
+// SetSynonyms sets the groups of words which are one subcluster
+func (x *WordIndex) SetSynonyms(synonyms Synonyms) {
+	x.synonyms = synonyms
+}
+
+// Synonyms returns the synonym groups of the index, nil if there are none
+func (x *WordIndex) Synonyms() Synonyms {
+	return x.synonyms
+}
+
+// Word returns the word by its id
+func (x *WordIndex) Word(id uint32) string {
+	return x.words[id]
+}
+
+// RowWords returns word ids of the row lemma, nil if the row is not indexed
+func (x *WordIndex) RowWords(row *Row) []uint32 {
+	position, ok := x.positions[row]
+	if !ok {
+		return nil
+	}
+	return x.rowWords[position]
+}
+
+// Select returns the rows which are not removed and contain all the words.
+func (x *WordIndex) Select(words []string) []*Row {
+	forms := make([][]string, len(words))
+	for i, word := range words {
+		forms[i] = []string{word}
+	}
+	return x.selectForms(forms)
+}
+
+// selectForms is Select where every word matches any of its forms, see plainQueryForms.
+// Posting lists are intersected starting from the shortest one.
+func (x *WordIndex) selectForms(forms [][]string) []*Row {
+	if len(forms) == 0 {
+		return x.Rows()
+	}
+	lists := make([][]int32, 0, len(forms))
+	for _, group := range forms {
+		var list []int32
+		for _, word := range group {
+			if id, ok := x.wordIDs[word]; ok {
+				list = unionPostings(list, x.postings[id])
+			}
+		}
+		if len(list) == 0 {
+			return nil
+		}
+		lists = append(lists, list)
+	}
+	sort.Slice(lists, func(i, j int) bool {
+		return len(lists[i]) < len(lists[j])
+	})
+
+	positions := lists[0]
+	for _, list := range lists[1:] {
+		positions = intersectPostings(positions, list)
+		if len(positions) == 0 {
+			return nil
+		}
+	}
+
+	var rows []*Row
+	for _, position := range positions {
+		if !x.isRemoved(position) {
+			rows = append(rows, x.rows[position])
+		}
+	}
+	return rows
+}
+
+// SelectIn returns the rows of the list which contain all the words, as selectForms does for the rows
+// which are not removed. The removed rows are not read, so the list can be filtered while rows are cut.
+func (x *WordIndex) SelectIn(rows []*Row, forms [][]string) []*Row {
+	if len(forms) == 0 {
+		return rows
+	}
+	// Every word matches the ids of its forms
+	groups := make([]map[uint32]struct{}, len(forms))
+	for i, group := range forms {
+		groups[i] = make(map[uint32]struct{}, len(group))
+		for _, word := range group {
+			if id, ok := x.wordIDs[word]; ok {
+				groups[i][id] = struct{}{}
+			}
+		}
+		if len(groups[i]) == 0 {
+			return nil
+		}
+	}
+
+	var selected []*Row
+	for _, row := range rows {
+		ids := x.RowWords(row)
+		matched := len(ids) > 0
+		for _, group := range groups {
+			if !containsWordID(ids, group) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			selected = append(selected, row)
+		}
+	}
+	return selected
+}
+
+func containsWordID(ids []uint32, group map[uint32]struct{}) bool {
+	for _, id := range ids {
+		if _, ok := group[id]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Group splits the rows by their words. Rows which are not indexed are skipped.
+// Groups are counted first, so all of them are filled in one allocated array.
+func (x *WordIndex) Group(rows []*Row) map[uint32][]*Row {
+	// Number of rows of every word and then the end of its group
+	ends := make([]int32, len(x.words))
+	var used []uint32
+	for _, row := range rows {
+		for _, id := range x.RowWords(row) {
+			if ends[id] == 0 {
+				used = append(used, id)
+			}
+			ends[id]++
+		}
+	}
+
+	starts := make([]int32, len(x.words))
+	var total int32
+	for _, id := range used {
+		starts[id] = total
+		total += ends[id]
+		ends[id] = starts[id]
+	}
+
+	backing := make([]*Row, total)
+	for _, row := range rows {
+		for _, id := range x.RowWords(row) {
+			end := ends[id]
+			// A word repeated in the lemma is counted once
+			if end > starts[id] && backing[end-1] == row {
+				continue
+			}
+			backing[end] = row
+			ends[id]++
+		}
+	}
+
+	groups := make(map[uint32][]*Row, len(used))
+	for _, id := range used {
+		groups[id] = backing[starts[id]:ends[id]]
+	}
+	return groups
+}
+
+// unionPostings merges two sorted posting lists
+func unionPostings(a, b []int32) []int32 {
+	if len(a) == 0 {
+		return b
+	}
+	ret := make([]int32, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			ret = append(ret, a[i])
+			i++
+		case a[i] > b[j]:
+			ret = append(ret, b[j])
+			j++
+		default:
+			ret = append(ret, a[i])
+			i++
+			j++
+		}
+	}
+	ret = append(ret, a[i:]...)
+	return append(ret, b[j:]...)
+}
+
+// intersectPostings intersects two sorted posting lists
+func intersectPostings(a, b []int32) []int32 {
+	var ret []int32
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			ret = append(ret, a[i])
+			i++
+			j++
+		}
+	}
+	return ret
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// Number of rows of the generated benchmark project
+const benchmarkRows = 200000
+
+// benchmarkQuery has a frequent and a rare word, like a typical cut
+var benchmarkQuery = []string{"w1", "w37"}
+
+// generateRows returns rows of 2-6 words. Word frequencies decrease like in real keywords:
+// few words are in many rows and most words are rare.
+func generateRows(count int) []*Row {
+	random := rand.New(rand.NewSource(1))
+	rows := make([]*Row, count)
+	for i := range rows {
+		words := make([]string, 2+random.Intn(5))
+		for j := range words {
+			words[j] = fmt.Sprintf("w%d", int(random.ExpFloat64()*200))
+		}
+		keyword := strings.Join(words, " ")
+		rows[i] = &Row{Keyword: keyword, NormalizedKeyword: keyword, Frequency: uint32(random.Intn(1000))}
+	}
+	return rows
+}
+
+// wordMapRows is the word map which the subclusters were built from before the index
+func wordMapRows(rows []*Row) map[string][]*Row {
+	wordMap := make(map[string][]*Row)
+	for _, row := range rows {
+		for _, word := range strings.Fields(row.NormalizedKeyword) {
+			wordMap[word] = append(wordMap[word], row)
+		}
+	}
+	return wordMap
+}
+
+func TestWordIndexSelect(t *testing.T) {
+	rows := generateRows(10000)
+	index := NewWordIndex(rows)
+	index.Remove(rows[:1000])
+	want := filterRows(strings.Join(benchmarkQuery, " "), rows[1000:])
+	got := index.Select(benchmarkQuery)
+	if len(got) != len(want) {
+		t.Fatalf("index selects %d rows, filter selects %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("row %d is %q, want %q", i, got[i].Keyword, want[i].Keyword)
+		}
+	}
+}
+
+func TestWordIndexGroup(t *testing.T) {
+	rows := generateRows(10000)
+	index := NewWordIndex(rows)
+	want := wordMapRows(rows)
+	groups := index.Group(rows)
+	if len(groups) != len(want) {
+		t.Fatalf("index has %d groups, word map has %d", len(groups), len(want))
+	}
+	for id, group := range groups {
+		// The word map repeats a row for every occurrence of the word
+		if len(group) > len(want[index.Word(id)]) || len(group) == 0 {
+			t.Fatalf("word %s has %d rows, want %d", index.Word(id), len(group), len(want[index.Word(id)]))
+		}
+	}
+}
+
+func BenchmarkFilterRows(b *testing.B) {
+	rows := generateRows(benchmarkRows)
+	query := strings.Join(benchmarkQuery, " ")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		filterRows(query, rows)
+	}
+}
+
+func BenchmarkWordIndexSelect(b *testing.B) {
+	index := NewWordIndex(generateRows(benchmarkRows))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.Select(benchmarkQuery)
+	}
+}
+
+func BenchmarkGenerateWordMap(b *testing.B) {
+	rows := generateRows(benchmarkRows)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		wordMapRows(rows)
+	}
+}
+
+func BenchmarkWordIndexGroup(b *testing.B) {
+	rows := generateRows(benchmarkRows)
+	index := NewWordIndex(rows)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.Group(rows)
+	}
+}
+
+func BenchmarkNewWordIndex(b *testing.B) {
+	rows := generateRows(benchmarkRows)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewWordIndex(rows)
+	}
+}
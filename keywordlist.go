@@ -9,53 +9,126 @@ import (
 	"unicode/utf8"
 )
 
-const (
-	MovementNone = iota
-	MovementUp
-	MovementDown
-)
-
 type KeywordListItem struct {
 	Text         string
 	Volume       uint32
 	StrongVolume uint32
+	// Row of the item, operations with the selected items cut the rows
+	Row *Row
 }
 
+// KeywordList shows the keywords of a cluster with a cursor and selected items:
+// Space selects the item, Shift with the arrows selects a range, Ctrl+A selects all items
+// and Esc clears the selection. Other keys are passed to the control function.
 type KeywordList struct {
 	*tview.Box
-	rows     []KeywordListItem
-	yOffset  int
-	movement int
+	rows    []KeywordListItem
+	yOffset int
+	// Index of the item under the cursor
+	current int
+
+	selected map[int]bool
+	// Selection before the range which is extended from the anchor by Shift
+	rangeBase map[int]bool
+	anchor    int
+
+	controlCallback func(key *tcell.EventKey)
 }
 
 func NewKeywordList(rows []KeywordListItem) *KeywordList {
 	return &KeywordList{
 		Box:      tview.NewBox(),
 		rows:     rows,
-		movement: MovementNone,
+		selected: make(map[int]bool),
 	}
 }
 
 func (r *KeywordList) SetKeywords(rows []KeywordListItem) {
 	r.rows = rows
 	r.yOffset = 0
+	r.current = 0
+	r.ClearSelection()
 }
 
 func (r *KeywordList) Clear() {
 	r.rows = nil
+	r.ClearSelection()
+}
+
+// SetControlFunc sets the function which is called on the keys the list doesn't handle
+func (r *KeywordList) SetControlFunc(handler func(key *tcell.EventKey)) *KeywordList {
+	r.controlCallback = handler
+	return r
+}
+
+// ClearSelection deselects all items
+func (r *KeywordList) ClearSelection() {
+	r.selected = make(map[int]bool)
+	r.rangeBase = nil
+}
+
+// SelectedRows returns the rows of the selected items in the list order,
+// the row under the cursor if no items are selected
+func (r *KeywordList) SelectedRows() []*Row {
+	var rows []*Row
+	for i, item := range r.rows {
+		if r.selected[i] {
+			rows = append(rows, item.Row)
+		}
+	}
+	if len(rows) == 0 && r.current < len(r.rows) {
+		rows = append(rows, r.rows[r.current].Row)
+	}
+	return rows
+}
+
+// moveCursor moves the cursor by the delta. If extend is true the items between
+// the anchor and the cursor are selected in addition to the selection before the range.
+func (r *KeywordList) moveCursor(delta int, extend bool) {
+	if len(r.rows) == 0 {
+		return
+	}
+	if !extend {
+		r.rangeBase = nil
+	} else if r.rangeBase == nil {
+		r.rangeBase = r.selected
+		r.anchor = r.current
+	}
+
+	r.current += delta
+	if r.current < 0 {
+		r.current = 0
+	}
+	if r.current >= len(r.rows) {
+		r.current = len(r.rows) - 1
+	}
+
+	if extend {
+		r.selected = make(map[int]bool, len(r.rangeBase))
+		for i := range r.rangeBase {
+			r.selected[i] = true
+		}
+		from, to := r.anchor, r.current
+		if from > to {
+			from, to = to, from
+		}
+		for i := from; i <= to; i++ {
+			r.selected[i] = true
+		}
+	}
 }
 
 func (r *KeywordList) Draw(screen tcell.Screen) {
 	r.Box.Draw(screen)
 	x, y, width, height := r.GetInnerRect()
 
-	if r.movement == MovementUp && r.yOffset > 0 {
-		r.yOffset--
+	// Keep the cursor in the viewport
+	if r.current < r.yOffset {
+		r.yOffset = r.current
 	}
-	if r.movement == MovementDown && r.yOffset < (len(r.rows)-height) {
-		r.yOffset++
+	if r.current >= r.yOffset+height {
+		r.yOffset = r.current - height + 1
 	}
-	r.movement = MovementNone
 
 	volumeLength := 9
 	strongVolumeLength := 6
@@ -68,6 +141,11 @@ func (r *KeywordList) Draw(screen tcell.Screen) {
 			break
 		}
 
+		// Selected items are marked, the mark column is kept for all items
+		mark := "  "
+		if r.selected[index] {
+			mark = "• "
+		}
 		text := row.Text
 		volumeText := fmt.Sprintf(" │%"+strconv.Itoa(volumeLength)+"v", row.Volume)
 		strongVolumeText := fmt.Sprintf(" │%"+strconv.Itoa(strongVolumeLength)+"v", row.StrongVolume)
@@ -82,17 +160,26 @@ func (r *KeywordList) Draw(screen tcell.Screen) {
 			strongVolumeText = ""
 		}
 
-		length := utf8.RuneCountInString(row.Text) +
+		length := utf8.RuneCountInString(mark) +
+			utf8.RuneCountInString(row.Text) +
 			utf8.RuneCountInString(volumeText) +
 			utf8.RuneCountInString(strongVolumeText)
 		if length > width {
-			textLength := width - utf8.RuneCountInString(strongVolumeText) -
+			textLength := width - utf8.RuneCountInString(mark) - utf8.RuneCountInString(strongVolumeText) -
 				utf8.RuneCountInString(volumeText) - 1
-			text = string([]rune(text)[:textLength]) + "\u2026"
+			text = string([]rune(text)[:textLength]) + "…"
 		} else {
 			text = text + strings.Repeat(" ", width-length)
 		}
-		line := fmt.Sprintf(`%s[yellow]%s[yellow]%s[yellow]%s`, text, strongVolumeText, volumeText)
+
+		color, volumeColor := "[white]", "[yellow]"
+		if r.selected[index] {
+			color = "[green]"
+		}
+		if index == r.current && r.HasFocus() {
+			color, volumeColor = "[black:white]", "[black:white]"
+		}
+		line := fmt.Sprintf(`%s%s%s%s%s`, color, tview.Escape(mark+text), volumeColor, strongVolumeText, volumeText)
 		tview.Print(screen, line, x, y+index-r.yOffset, width, tview.AlignLeft, tcell.ColorWhite)
 	}
 }
@@ -119,13 +206,45 @@ func Format(n uint32) string {
 // InputHandler returns the handler for this primitive.
 func (t *KeywordList) InputHandler() func(event *tcell.EventKey, setFocus func(p tview.Primitive)) {
 	return t.WrapInputHandler(func(event *tcell.EventKey, setFocus func(p tview.Primitive)) {
-		// Because the tree is flattened into a list only at drawing time, we also
-		// postpone the (selection) movement to drawing time.
+		_, _, _, height := t.GetInnerRect()
+		extend := event.Modifiers()&tcell.ModShift != 0
 		switch key := event.Key(); key {
 		case tcell.KeyDown:
-			t.movement = MovementDown
+			t.moveCursor(1, extend)
 		case tcell.KeyUp:
-			t.movement = MovementUp
+			t.moveCursor(-1, extend)
+		case tcell.KeyPgDn:
+			t.moveCursor(height, extend)
+		case tcell.KeyPgUp:
+			t.moveCursor(-height, extend)
+		case tcell.KeyHome:
+			t.moveCursor(-len(t.rows), extend)
+		case tcell.KeyEnd:
+			t.moveCursor(len(t.rows), extend)
+		case tcell.KeyCtrlA:
+			// Select all or deselect if all items are selected
+			all := len(t.selected) < len(t.rows)
+			t.ClearSelection()
+			for i := range t.rows {
+				if all {
+					t.selected[i] = true
+				}
+			}
+		case tcell.KeyEscape:
+			t.ClearSelection()
+		default:
+			if event.Rune() == ' ' {
+				if t.current < len(t.rows) {
+					if t.selected[t.current] {
+						delete(t.selected, t.current)
+					} else {
+						t.selected[t.current] = true
+					}
+					t.moveCursor(1, false)
+				}
+			} else if t.controlCallback != nil {
+				t.controlCallback(event)
+			}
 		}
 	})
 }
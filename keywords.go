@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"strings"
 )
 
@@ -12,8 +13,8 @@ type Row struct {
 }
 
 
+// filterRows returns the rows which contain all words of the keyword
 func filterRows(keyword string, rows []*Row) []*Row {
-	wordMap := generateWordMap(rows)
 	keywordWords := strings.Fields(keyword)
 
 	if len(keywordWords) == 0 {
@@ -21,66 +22,91 @@ func filterRows(keyword string, rows []*Row) []*Row {
 	}
 
 	var goodRows []*Row
-	firstWord := keywordWords[0]
-	if keywordRows, ok := wordMap[firstWord]; ok {
-		for i, row := range keywordRows {
-			rowWords := strings.Fields(row.NormalizedKeyword)
-			if contains(rowWords, keywordWords[1:]) {
-				goodRows = append(goodRows, keywordRows[i])
-			}
+	for _, row := range rows {
+		rowWords := strings.Fields(row.NormalizedKeyword)
+		if contains(rowWords, keywordWords) {
+			goodRows = append(goodRows, row)
 		}
 	}
 
 	return goodRows
 }
 
-func ApplyHistory(rows []*Row, history *History) (ret []*Row) {
-	ret = rows
-	var keywords []string
+// Parameter normalizer is used by operations with query syntax and can be nil
+func ApplyHistory(rows []*Row, history *History, normalizer Normalizer) ([]*Row, error) {
+	index := NewWordIndex(rows)
+	if err := applyHistory(index, history, normalizer); err != nil {
+		return nil, err
+	}
+	return index.Rows(), nil
+}
+
+// applyHistory removes the rows of the applied operations from the index
+func applyHistory(index *WordIndex, history *History, normalizer Normalizer) error {
 	for i, operation := range history.Operations {
 		if i > history.CurrentStateIndex {
 			break
 		}
-		keywords = append(keywords, operation.Keyword)
+		if err := applyOperation(index, history.Operations[:i], operation, normalizer); err != nil {
+			return fmt.Errorf("operation %d \"%s\": %v", i+1, operation.Keyword, err)
+		}
 	}
-	ret = removeRows(keywords, rows)
-	return
+	return nil
 }
 
+// applyOperation cuts the rows of the operation from the index. A restore operation
+// takes its rows back from the clusters of the previous operations.
+func applyOperation(index *WordIndex, previous []*KeywordOperation, op *KeywordOperation, normalizer Normalizer) error {
+	if op.Operation == OperationRestore {
+		op.takeRows(previous)
+		index.Restore(op.rows)
+		return nil
+	}
+	// Merged rows are moved between the clusters, so the remaining rows are not changed
+	if op.Merge != "" {
+		op.takeRows(previous)
+		return nil
+	}
+	rows, err := selectOperationRows(index, op, normalizer)
+	if err != nil {
+		return err
+	}
+	op.setRows(rows)
+	index.Remove(op.rows)
+	return nil
+}
 
-func removeRows(keywords []string, rows []*Row) []*Row {
-	// [
-	// 	"грыжа": [Row1, Row2],
-	//	"боль" : [Row2, Row3]
-	// ]
-	wordMap := generateWordMap(rows)
-
-
-	rowMap := convertRowsToMap(rows)
-	for _, keyword := range keywords {
-		keywordWords := strings.Fields(keyword)
-
-		if len(keywordWords) == 0 {
-			continue
-		}
+// selectOperationRows returns the rows of the index which are not removed and are cut by the operation
+func selectOperationRows(index *WordIndex, op *KeywordOperation, normalizer Normalizer) ([]*Row, error) {
+	if op.Keywords != nil {
+		return selectKeywordRows(index.Rows(), op.Keywords), nil
+	}
+	return selectIndexRows(index, op.Keyword, normalizer, op.querySynonyms(index))
+}
 
-		firstWord := keywordWords[0]
-		if keywordRows, ok := wordMap[firstWord]; ok {
-			var leftRows []*Row
-			for i, row := range keywordRows {
-				rowWords := strings.Fields(row.NormalizedKeyword)
-				if contains(rowWords, keywordWords[1:]) {
-					delete(rowMap, keywordRows[i])
-				} else {
-					leftRows = append(leftRows, keywordRows[i])
-				}
-			}
-			wordMap[firstWord] = leftRows
+// selectKeywordRows returns the rows with the keywords
+func selectKeywordRows(rows []*Row, keywords []string) []*Row {
+	set := stringsToMap(keywords)
+	var selected []*Row
+	for _, row := range rows {
+		if _, ok := set[row.Keyword]; ok {
+			selected = append(selected, row)
 		}
 	}
+	return selected
+}
 
-	rows = convertMapToRows(rowMap)
-	return rows
+// selectIndexRows returns the rows of the index which are not removed and match the query.
+// Words of the query match their groups of the synonyms, which can be nil.
+func selectIndexRows(index *WordIndex, keyword string, normalizer Normalizer, synonyms Synonyms) ([]*Row, error) {
+	if isPlainQuery(keyword) {
+		return index.selectForms(plainQueryForms(keyword, normalizer, synonyms)), nil
+	}
+	query, err := parseQuerySynonyms(keyword, normalizer, synonyms)
+	if err != nil {
+		return nil, err
+	}
+	return queryRows(query, index.Rows()), nil
 }
 
 func contains(arr []string, subArray []string) bool {
@@ -109,16 +135,3 @@ func stringsToMap(arr []string) map[string]struct{} {
 
 	return m
 }
-
-func generateWordMap(rows []*Row) map[string][]*Row{
-	wordMap := make(map[string][]*Row)
-
-	// Generating map of rows for every word
-	for i, r := range rows {
-		words := strings.Fields(r.NormalizedKeyword)
-		for _, word := range words {
-			wordMap[word] = append(wordMap[word], rows[i])
-		}
-	}
-	return wordMap
-}
\ No newline at end of file
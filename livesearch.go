@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Delay after the last keystroke before the tree of the typed query is computed
+const searchDelay = 300 * time.Millisecond
+
+// Rows are filtered by chunks, so a canceled search stops quickly
+const searchChunkSize = 4096
+
+var errSearchCanceled = errors.New("search is canceled")
+
+// liveSearch computes the tree of the typed query in the background.
+// Its fields are used only by the UI goroutine.
+type liveSearch struct {
+	timer *time.Timer
+	// Number of the last typed query, results of the previous ones are dropped
+	generation int
+	// Closed to cancel the running computation
+	cancel chan struct{}
+}
+
+// stop cancels the delayed and the running computation without waiting for it
+func (s *liveSearch) stop() {
+	s.generation++
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if s.cancel != nil {
+		close(s.cancel)
+		s.cancel = nil
+	}
+}
+
+func isCanceled(cancel <-chan struct{}) bool {
+	select {
+	case <-cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// TypeSearch is called when the query is typed. The running computation is canceled
+// and the tree of the query is computed after the delay if nothing else is typed.
+func (app *App) TypeSearch(query string) {
+	search := &app.State.Temp.Search
+	search.stop()
+	if query == app.State.Temp.Keyword {
+		app.Primitives.ClusterTree.SetComputing(false)
+		return
+	}
+	generation := search.generation
+	search.timer = time.AfterFunc(searchDelay, func() {
+		app.UI.QueueUpdate(func() {
+			if generation == app.State.Temp.Search.generation {
+				app.startSearch(query, generation)
+			}
+		})
+	})
+}
+
+// CancelSearch cancels the live search, so its tree is never shown.
+// It's called before the tree is changed by other actions.
+func (app *App) CancelSearch() {
+	app.State.Temp.Search.stop()
+	app.Primitives.ClusterTree.SetComputing(false)
+}
+
+// startSearch computes the tree of the query in the background. The remaining rows, the settings
+// and a copy of the index are taken now, so rows can be cut and the settings can be changed
+// while the tree is computed. The tree is shown if no other query is typed until then,
+// a canceled computation is not waited for and its result is dropped.
+func (app *App) startSearch(query string, generation int) {
+	cancel := make(chan struct{})
+	app.State.Temp.Search.cancel = cancel
+
+	project := &app.State.Project
+	rows := project.Rows
+	// The removed bitmap of the copy is still changed by cuts, but it's never read by the search.
+	// Stop words and synonyms are replaced by new values, so the copy keeps the current ones.
+	snapshot := *project.Index
+	index := &snapshot
+	normalizer := project.Normalizer
+	synonyms := project.expandSynonyms()
+	minSize, order := project.Config.MinClusterSize, project.Config.ClusterSort
+	app.Primitives.ClusterTree.SetComputing(true)
+
+	go func() {
+		defer app.recoverBackground()
+		var node *ClusterNode
+		selected, err := selectRowsFrom(index, query, rows, normalizer, synonyms, cancel)
+		if err == nil && !isCanceled(cancel) {
+			root := NewCluster(query, selected, nil)
+			root.Index = index
+			node = newRootNode(query, root, minSize, order)
+		}
+		if err == errSearchCanceled || isCanceled(cancel) {
+			return
+		}
+
+		app.UI.QueueUpdateDraw(func() {
+			if generation != app.State.Temp.Search.generation {
+				return
+			}
+			app.Primitives.ClusterTree.SetComputing(false)
+			if err != nil {
+				app.SetStatusBarText("[red]Ошибка в запросе:[white] " + err.Error())
+				return
+			}
+			// Subclusters of the expanded nodes use the index of the project from now on
+			node.Walk(func(node, parent *ClusterNode) bool {
+				node.Cluster.Index = project.Index
+				return true
+			})
+			app.setRootNode(query, node, node)
+			app.UpdateKeywordList()
+			app.UpdateStatusBar()
+		})
+	}()
+}
+
+// selectRowsFrom returns the rows of the list which match the query as selectIndexRows does
+// for the rows which are not removed. It reads only the words of the index, so the list
+// can be a snapshot of the remaining rows which is filtered while rows are cut.
+func selectRowsFrom(index *WordIndex, query string, rows []*Row, normalizer Normalizer, synonyms bool,
+	cancel <-chan struct{}) ([]*Row, error) {
+	if strings.TrimSpace(query) == "" {
+		return rows, nil
+	}
+	var groups Synonyms
+	if synonyms {
+		groups = index.Synonyms()
+	}
+	var match func(rows []*Row) []*Row
+	if isPlainQuery(query) {
+		forms := plainQueryForms(query, normalizer, groups)
+		match = func(rows []*Row) []*Row {
+			return index.SelectIn(rows, forms)
+		}
+	} else {
+		q, err := parseQuerySynonyms(query, normalizer, groups)
+		if err != nil {
+			return nil, err
+		}
+		match = func(rows []*Row) []*Row {
+			return queryRows(q, rows)
+		}
+	}
+
+	var selected []*Row
+	for start := 0; start < len(rows); start += searchChunkSize {
+		if isCanceled(cancel) {
+			return nil, errSearchCanceled
+		}
+		end := start + searchChunkSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		selected = append(selected, match(rows[start:end])...)
+	}
+	return selected, nil
+}
@@ -6,14 +6,42 @@ import (
 	"github.com/gdamore/tcell"
 	"github.com/rivo/tview"
 	"os"
+	"strings"
 )
 
 // To run this app:
 // $ tool -p ./ProjectName
 // $ tool -p ./ProjectName -f ./keyword.csv
 
+// Exit codes
+const (
+	exitError = 1 // the project can't be loaded or saved
+	exitUsage = 2 // wrong flags
+)
+
+// usageError is an error of the command line flags
+type usageError struct {
+	message string
+}
+
+func (e *usageError) Error() string {
+	return e.message
+}
+
 func main() {
-	project := loadProjectCLI()
+	if len(os.Args) > 1 {
+		if run, ok := commands[os.Args[1]]; ok {
+			if err := run(os.Args[2:], os.Stdout); err != nil {
+				exit(err)
+			}
+			return
+		}
+	}
+
+	project, err := loadProjectCLI()
+	if err != nil {
+		exit(err)
+	}
 	// Nil means there are no things to do
 	if project == nil {
 		return
@@ -23,43 +51,154 @@ func main() {
 	app.State.Project = *project
 	app.State.Temp.CachedClusters = make(map[string]*Cluster)
 	app.UI = tview.NewApplication()
+	project.writer.SetErrorFunc(func(err error) {
+		// The writer must not wait for the UI, the UI can wait for the writer
+		go app.UI.QueueUpdateDraw(func() {
+			app.SetStatusBarText("[red]Не удалось сохранить проект: " + tview.Escape(err.Error()))
+		})
+	})
 
 	root := initPrimitives(&app)
 
 	app.UpdateView()
-	if err := app.UI.SetRoot(root, true).SetFocus(root).Run(); err != nil {
-		panic(err)
+	err = app.Run(root)
+	project.writer.SetErrorFunc(nil)
+	if closeErr := app.State.Project.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		exit(err)
 	}
 }
 
-func loadProjectCLI() (project *Project) {
+// exit prints the error and exits with the code of the error
+func exit(err error) {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	if _, ok := err.(*usageError); ok {
+		fmt.Fprintln(os.Stderr, "Run with -help to see the flags")
+		os.Exit(exitUsage)
+	}
+	os.Exit(exitError)
+}
+
+func loadProjectCLI() (*Project, error) {
 	pFlag := flag.String("p", "", "Project name")
-	fFlag := flag.String("f", "", "Keywords csv file")
-	update := flag.Bool("update", false, "Re-cut all keywords in history.txt")
+	var files, appendFiles filesFlag
+	flag.Var(&files, "f", "Keywords csv or xlsx file, can be repeated")
+	flag.Var(&appendFiles, "append", "Keywords file to add into the existing project, can be repeated")
+	merge := MergeOptions{}
+	flag.StringVar(&merge.Dedup, "dedup", DedupExact, "Deduplicate keywords by: exact or lemma")
+	flag.StringVar(&merge.Frequency, "merge", MergeMax, "Frequency of duplicates: max, sum or first")
+	update := flag.Bool("update", false, "Re-cut all keywords of the project history")
+	source := SourceOptions{}
+	flag.StringVar(&source.Columns.Keyword, "col-keyword", "", "Header of the keyword column")
+	flag.StringVar(&source.Columns.Lemma, "col-lemma", "", "Header of the lemma column")
+	flag.StringVar(&source.Columns.Frequency, "col-freq", "", "Header of the broad frequency column")
+	flag.StringVar(&source.Columns.StrongFrequency, "col-strong-freq", "", "Header of the exact frequency column")
+	flag.StringVar(&source.Normalizer, "normalizer", "", "Normalizer of keywords without lemma: stemmer or lower")
+	flag.StringVar(&source.Delimiter, "delimiter", "", "Field delimiter of the keywords file: \",\", \";\", \"tab\" or \"|\"")
+	flag.StringVar(&source.Encoding, "encoding", "", "Encoding of the keywords file: utf-8, cp1251, utf-16le or utf-16be")
+	flag.StringVar(&source.Sheet, "sheet", "", "Name or number of the sheet of the xlsx keywords file")
+	clusterFormat := flag.String("cluster-format", "", "Format of the cluster files: csv or xlsx")
+	workbook := flag.String("workbook", "", "Export all clusters into one xlsx workbook and exit")
+	rules := flag.String("apply", "", "Apply the operations of the rules file to the project and exit")
 	helpFlag := flag.Bool("help", false, "Project name")
 
 	flag.Parse()
 
 	if *helpFlag {
 		showHelp()
-		return nil
+		return nil, nil
 	}
 
 	if *pFlag == "" {
-		panic("-p flag must be specified")
+		return nil, &usageError{"-p flag must be specified"}
+	}
+	if err := merge.Validate(); err != nil {
+		return nil, &usageError{err.Error()}
+	}
+	if *clusterFormat != "" && *clusterFormat != ClusterFormatCSV && *clusterFormat != ClusterFormatXLSX {
+		return nil, &usageError{"-cluster-format must be csv or xlsx"}
 	}
 
-	if *fFlag == "" {
-		project = LoadProject(*pFlag, *update)
+	var ruleOperations []*KeywordOperation
+	if *rules != "" {
+		var err error
+		if ruleOperations, err = LoadRules(*rules); err != nil {
+			return nil, err
+		}
+	}
+
+	var project *Project
+	var err error
+	if len(files) == 0 {
+		project, err = LoadProject(*pFlag, source, *update)
 	} else {
-		project = CreateProject(*pFlag, *fFlag, *update)
+		project, err = CreateProject(*pFlag, files, source, merge, *update)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := runCLICommands(project, appendFiles, source, merge, *clusterFormat, ruleOperations, *workbook); err != nil {
+		project.Close()
+		return nil, err
+	}
+
+	if *workbook != "" || *rules != "" {
+		return nil, project.Close()
+	}
+	return project, nil
+}
+
+// runCLICommands changes the loaded project according to the flags
+func runCLICommands(project *Project, appendFiles []string, source SourceOptions, merge MergeOptions, clusterFormat string,
+	rules []*KeywordOperation, workbook string) error {
+	for _, file := range appendFiles {
+		if err := project.AppendSource(file, source, merge); err != nil {
+			return err
+		}
+	}
+
+	if clusterFormat != "" {
+		project.Config.ClusterFormat = clusterFormat
+		if err := project.Config.Save(project.Paths.ConfigFile); err != nil {
+			return err
+		}
+	}
+
+	if rules != nil {
+		results, err := project.ApplyRules(rules)
+		printRulesSummary(os.Stdout, results, len(project.Rows))
+		if err != nil {
+			return err
+		}
+	}
+
+	if workbook != "" {
+		if err := project.ExportWorkbook(workbook); err != nil {
+			return err
+		}
+		fmt.Printf("Clusters are exported into %s\n", workbook)
 	}
+	return nil
+}
+
+// filesFlag collects values of the repeated flag
+type filesFlag []string
+
+func (f *filesFlag) String() string {
+	return strings.Join(*f, ", ")
+}
 
-	return
+func (f *filesFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
 }
 
 func initPrimitives(app *App) (root *tview.Pages) {
 	currentPage := "Main"
+	pages := tview.NewPages()
 
 	searchInput := tview.NewInputField()
 	statusBar := tview.NewTextView()
@@ -82,6 +221,11 @@ func initPrimitives(app *App) (root *tview.Pages) {
 	keywordList.SetBorderPadding(0, 0, 1, 0).SetBorder(true).SetTitle("Запросы")
 
 	clusterTree.SetBorder(true).SetTitle("Все слова")
+	clusterTree.SetColumns(app.State.Project.Config.TreeColumns)
+	clusterTree.SetStatsFunc(func() {
+		app.UI.QueueUpdateDraw(func() {})
+	})
+	clusterTree.SetPanicFunc(app.Fail)
 	clusterTree.SetNavigatedFunc(func(node *ClusterNode) {
 		app.State.Temp.SelectedNode = node
 		app.UpdateKeywordList()
@@ -89,15 +233,16 @@ func initPrimitives(app *App) (root *tview.Pages) {
 	})
 	clusterTree.SetSelectedFunc(func(node *ClusterNode) {
 		if len(node.children) == 0 {
-			children := node.GenerateChildren(app.State.Temp.CachedClusters)
-			sortClusterNodes(children)
-			node.SetChildren(children)
+			app.GenerateChildren(node)
 		}
 		node.IsExpanded = !node.IsExpanded
 	})
 	clusterTree.SetControlFunc(func(node *ClusterNode, key *tcell.EventKey) {
 		if key.Key() == tcell.KeyCtrlK {
-			app.SearchKeyword(node.Name)
+			if err := app.SearchKeyword(node.Name); err != nil {
+				app.SetStatusBarText("[red]Ошибка в запросе:[white] " + err.Error())
+				return
+			}
 			app.UpdateView()
 			app.SetStatusBarText("Теперь корневой запрос: " + node.Name)
 		} else if key.Key() == tcell.KeyCtrlA {
@@ -105,43 +250,124 @@ func initPrimitives(app *App) (root *tview.Pages) {
 			app.UpdateView()
 			app.SetStatusBarText("Теперь показываются все слова")
 		} else if key.Key() == tcell.KeyCtrlD {
-			cut := filterRows(node.Name, app.State.Project.Rows)
-			app.ProcessOperation(node.Name, cut, OperationRemove)
+			cut, err := app.State.Project.Select(node.Name)
+			if err != nil {
+				app.SetStatusBarText("[red]Ошибка в запросе:[white] " + err.Error())
+				return
+			}
+			if err := app.ProcessOperation(node.Name, cut, OperationRemove); err != nil {
+				app.SetStatusBarText("[red]Операция не выполнена:[white] " + tview.Escape(err.Error()))
+				return
+			}
 			if len(node.Neighbors) > 0 {
 				app.ExpandAndSelect(node.Neighbors[0].GetFullName())
 			}
 			app.UpdateView()
 			app.SetStatusBarText(fmt.Sprintf("Удален корневой кластер:[red] %s", node.Name))
 		} else if key.Rune() == '/' {
-			cut := filterRows(node.Name, app.State.Project.Rows)
-			app.ProcessOperation(node.Name, cut, OperationSilentRemove)
+			cut, err := app.State.Project.Select(node.Name)
+			if err != nil {
+				app.SetStatusBarText("[red]Ошибка в запросе:[white] " + err.Error())
+				return
+			}
+			if err := app.ProcessOperation(node.Name, cut, OperationSilentRemove); err != nil {
+				app.SetStatusBarText("[red]Операция не выполнена:[white] " + tview.Escape(err.Error()))
+				return
+			}
 			if len(node.Neighbors) > 0 {
 				app.ExpandAndSelect(node.Neighbors[0].GetFullName())
 			}
 			app.UpdateView()
 			app.SetStatusBarText(fmt.Sprintf("Удален без извлечения кластер:[red] %s", node.Name))
 		} else if key.Key() == tcell.KeyCtrlS {
-			cut := filterRows(node.Name, app.State.Project.Rows)
-			app.ProcessOperation(node.Name, cut, OperationAdd)
+			cut, err := app.State.Project.Select(node.Name)
+			if err != nil {
+				app.SetStatusBarText("[red]Ошибка в запросе:[white] " + err.Error())
+				return
+			}
+			if err := app.ProcessOperation(node.Name, cut, OperationAdd); err != nil {
+				app.SetStatusBarText("[red]Операция не выполнена:[white] " + tview.Escape(err.Error()))
+				return
+			}
 			if len(node.Neighbors) > 0 {
 				app.ExpandAndSelect(node.Neighbors[0].GetFullName())
 			}
 			app.UpdateView()
 			app.SetStatusBarText(fmt.Sprintf("Сохранен корневой кластер:[green] %s", node.Name))
 		} else if key.Rune() == '+' || key.Key() == tcell.KeyCtrlSpace {
-			app.ProcessOperation(node.GetFullName(), node.Rows, OperationAdd)
+			rows, err := app.NodeRows(node)
+			if err != nil {
+				app.SetStatusBarText("[red]Ошибка в запросе:[white] " + err.Error())
+				return
+			}
+			if err := app.ProcessOperation(node.GetFullName(), rows, OperationAdd); err != nil {
+				app.SetStatusBarText("[red]Операция не выполнена:[white] " + tview.Escape(err.Error()))
+				return
+			}
 			if len(node.Neighbors) > 0 {
 				app.ExpandAndSelect(node.Neighbors[0].GetFullName())
 			}
 			app.UpdateView()
 			app.SetStatusBarText(fmt.Sprintf("Сохранен кластер:[green] %s", node.GetFullName()))
 		} else if key.Key() == tcell.KeyBackspace2 || key.Rune() == '-' {
-			app.ProcessOperation(node.GetFullName(), node.Rows, OperationRemove)
+			rows, err := app.NodeRows(node)
+			if err != nil {
+				app.SetStatusBarText("[red]Ошибка в запросе:[white] " + err.Error())
+				return
+			}
+			if err := app.ProcessOperation(node.GetFullName(), rows, OperationRemove); err != nil {
+				app.SetStatusBarText("[red]Операция не выполнена:[white] " + tview.Escape(err.Error()))
+				return
+			}
 			if len(node.Neighbors) > 0 {
 				app.ExpandAndSelect(node.Neighbors[0].GetFullName())
 			}
 			app.UpdateView()
 			app.SetStatusBarText(fmt.Sprintf("Удален кластер:[red] %s", node.GetFullName()))
+		} else if key.Rune() == '>' {
+			rows, err := app.NodeRows(node)
+			if err != nil {
+				app.SetStatusBarText("[red]Ошибка в запросе:[white] " + err.Error())
+				return
+			}
+			query := node.GetFullName()
+			input := clusterInput(app, "Сохранение в кластер: "+query, app.State.Temp.LastCluster, func(text string, ok bool) {
+				pages.RemovePage("ClusterDialog")
+				pages.SwitchToPage("Main")
+				currentPage = "Main"
+				app.UI.SetFocus(clusterTree)
+				cluster := strings.TrimSpace(text)
+				if !ok || cluster == "" {
+					return
+				}
+				app.State.Temp.LastCluster = cluster
+				if err := app.ProcessOperationInto(query, cluster, rows, OperationAdd); err != nil {
+					app.SetStatusBarText("[red]Операция не выполнена:[white] " + tview.Escape(err.Error()))
+					return
+				}
+				if len(node.Neighbors) > 0 {
+					app.ExpandAndSelect(node.Neighbors[0].GetFullName())
+				}
+				app.UpdateView()
+				app.SetStatusBarText(fmt.Sprintf("Сохранен кластер:[green] %s[white] → %s", tview.Escape(query), tview.Escape(cluster)))
+			})
+			pages.AddAndSwitchToPage("ClusterDialog", input, true)
+			currentPage = "ClusterDialog"
+		} else if key.Rune() == '!' {
+			if node.Parent == nil {
+				app.SetStatusBarText("[red]Корневой запрос нельзя добавить в стоп-слова")
+				return
+			}
+			if !app.State.Project.AddStopWord(node.Name) {
+				app.SetStatusBarText(fmt.Sprintf("Уже стоп-слово: %s", node.Name))
+				return
+			}
+			app.SearchKeyword(app.State.Temp.Keyword)
+			if len(node.Neighbors) > 0 {
+				app.ExpandAndSelect(node.Neighbors[0].GetFullName())
+			}
+			app.UpdateView()
+			app.SetStatusBarText(fmt.Sprintf("Добавлено стоп-слово:[yellow] %s[white], список в config.json", node.Name))
 		}
 	})
 
@@ -150,9 +376,13 @@ func initPrimitives(app *App) (root *tview.Pages) {
 	searchInput.SetLabel(" Запрос: ")
 	searchInput.SetLabelColor(tcell.ColorWhite)
 	searchInput.SetFieldBackgroundColor(0x586E75)
+	searchInput.SetChangedFunc(app.TypeSearch)
 	searchInput.SetDoneFunc(func(key tcell.Key) {
 		if key == tcell.KeyEnter {
-			app.SearchKeyword(app.Primitives.Input.GetText())
+			if err := app.SearchKeyword(app.Primitives.Input.GetText()); err != nil {
+				app.SetStatusBarText("[red]Ошибка в запросе:[white] " + err.Error())
+				return
+			}
 			app.UpdateView()
 		}
 	})
@@ -168,9 +398,77 @@ func initPrimitives(app *App) (root *tview.Pages) {
 	grid.AddItem(mainFlex, 1, 0, 1, 4, 0, 0, false)
 	grid.AddItem(statusBar, 2, 0, 1, 4, 0, 0, false)
 
-	pages := tview.NewPages()
 	pages.AddPage("Main", grid, true, true)
 
+	// Selected keywords are moved into a named cluster (+), removed (-), removed without a file (/)
+	// or left in the remaining keywords when the current cluster is saved (x)
+	keywordList.SetControlFunc(func(key *tcell.EventKey) {
+		rows := keywordList.SelectedRows()
+		if len(rows) == 0 {
+			return
+		}
+		name := app.State.Temp.SelectedNode.GetFullName()
+		switch {
+		case key.Rune() == '+':
+			input := clusterInput(app, "Перенос запросов в кластер", app.State.Temp.LastCluster, func(text string, ok bool) {
+				pages.RemovePage("RowsDialog")
+				pages.SwitchToPage("Main")
+				currentPage = "Main"
+				app.UI.SetFocus(keywordList)
+				name := strings.TrimSpace(text)
+				if !ok || name == "" {
+					return
+				}
+				app.State.Temp.LastCluster = name
+				if err := app.ProcessRowsOperation(name, rows, OperationAdd); err != nil {
+					app.SetStatusBarText("[red]Операция не выполнена:[white] " + tview.Escape(err.Error()))
+					return
+				}
+				app.UpdateView()
+				app.SetStatusBarText(fmt.Sprintf("Запросов перенесено в кластер[green] %s[white]: %d", tview.Escape(name), len(rows)))
+			})
+			pages.AddAndSwitchToPage("RowsDialog", input, true)
+			currentPage = "RowsDialog"
+		case key.Rune() == '-' || key.Key() == tcell.KeyDelete:
+			if err := app.ProcessRowsOperation(name, rows, OperationRemove); err != nil {
+				app.SetStatusBarText("[red]Операция не выполнена:[white] " + tview.Escape(err.Error()))
+				return
+			}
+			app.UpdateView()
+			app.SetStatusBarText(fmt.Sprintf("Запросов удалено в[red] %s[white]: %d", tview.Escape(name), len(rows)))
+		case key.Rune() == '/':
+			if err := app.ProcessRowsOperation(name, rows, OperationSilentRemove); err != nil {
+				app.SetStatusBarText("[red]Операция не выполнена:[white] " + tview.Escape(err.Error()))
+				return
+			}
+			app.UpdateView()
+			app.SetStatusBarText(fmt.Sprintf("Запросов исключено без извлечения: %d", len(rows)))
+		case key.Rune() == 'x' || key.Rune() == 'ч':
+			node := app.State.Temp.SelectedNode
+			all, err := app.NodeRows(node)
+			if err != nil {
+				app.SetStatusBarText("[red]Ошибка в запросе:[white] " + err.Error())
+				return
+			}
+			cut := removeRowsFrom(all, rows)
+			if len(cut) == 0 {
+				app.SetStatusBarText("[red]Выбраны все запросы кластера, сохранять нечего")
+				return
+			}
+			// The cut keeps its keywords, so the excluded rows stay in the remaining ones when the history is applied again
+			if err := app.ProcessRowsOperation(name, cut, OperationAdd); err != nil {
+				app.SetStatusBarText("[red]Операция не выполнена:[white] " + tview.Escape(err.Error()))
+				return
+			}
+			if len(node.Neighbors) > 0 {
+				app.ExpandAndSelect(node.Neighbors[0].GetFullName())
+			}
+			app.UpdateView()
+			app.SetStatusBarText(fmt.Sprintf("Сохранен кластер[green] %s[white] без выбранных запросов: %d, в остатке осталось: %d",
+				tview.Escape(name), len(cut), len(all)-len(cut)))
+		}
+	})
+
 	tabPrimitives := []tview.Primitive{
 		searchInput,
 		clusterTree,
@@ -194,24 +492,118 @@ func initPrimitives(app *App) (root *tview.Pages) {
 					currentPrimitive--
 				}
 				app.UI.SetFocus(tabPrimitives[currentPrimitive])
+			} else if event.Key() == tcell.KeyCtrlZ {
+				app.Undo()
+				return tcell.NewEventKey(tcell.KeyNUL, 0, tcell.ModNone)
+			} else if event.Key() == tcell.KeyCtrlY {
+				app.Redo()
+				return tcell.NewEventKey(tcell.KeyNUL, 0, tcell.ModNone)
+			} else if event.Modifiers()&tcell.ModAlt != 0 && (event.Rune() == 'o' || event.Rune() == 'щ') {
+				config := app.State.Project.Config
+				next := 0
+				for i, order := range clusterSorts {
+					if order == config.ClusterSort {
+						next = (i + 1) % len(clusterSorts)
+					}
+				}
+				config.ClusterSort = clusterSorts[next]
+				app.RegenerateTree()
+				app.SetStatusBarText("Сортировка кластеров: " + clusterSortName(config.ClusterSort))
+				return tcell.NewEventKey(tcell.KeyNUL, 0, tcell.ModNone)
+			} else if event.Modifiers()&tcell.ModAlt != 0 && (event.Rune() == '=' || event.Rune() == '+' || event.Rune() == '-') {
+				config := app.State.Project.Config
+				if event.Rune() != '-' {
+					config.MinClusterSize++
+				} else if config.MinClusterSize > 1 {
+					config.MinClusterSize--
+				}
+				app.RegenerateTree()
+				app.SetStatusBarText(fmt.Sprintf("Минимальный размер кластера: %d", config.MinClusterSize))
+				return tcell.NewEventKey(tcell.KeyNUL, 0, tcell.ModNone)
+			} else if event.Modifiers()&tcell.ModAlt != 0 && (event.Rune() == 's' || event.Rune() == 'ы') {
+				config := app.State.Project.Config
+				if len(config.Synonyms) == 0 {
+					app.SetStatusBarText("В config.json проекта нет синонимов")
+					return tcell.NewEventKey(tcell.KeyNUL, 0, tcell.ModNone)
+				}
+				if config.SynonymCuts == SynonymCutsGroup {
+					config.SynonymCuts = SynonymCutsWord
+					app.SetStatusBarText("Операции вырезают только слово запроса")
+				} else {
+					config.SynonymCuts = SynonymCutsGroup
+					app.SetStatusBarText("Операции вырезают слово запроса вместе с синонимами")
+				}
+				app.State.Project.SaveConfig()
+				return tcell.NewEventKey(tcell.KeyNUL, 0, tcell.ModNone)
+			} else if event.Modifiers()&tcell.ModAlt != 0 && (event.Rune() == 'c' || event.Rune() == 'с') {
+				columns := columnsList(app, func() {
+					pages.SwitchToPage("Main")
+					currentPage = "Main"
+					pages.RemovePage("Columns")
+					app.UI.SetFocus(tabPrimitives[currentPrimitive])
+				})
+				pages.AddAndSwitchToPage("Columns", columns, true)
+				currentPage = "Columns"
+				return tcell.NewEventKey(tcell.KeyNUL, 0, tcell.ModNone)
 			} else if event.Modifiers()&tcell.ModAlt != 0 && (event.Rune() == 'h' || event.Rune() == 'р') {
-				history := historyList(app, func(operation *KeywordOperation) {
+				history := historyList(app, pages, func(operation *KeywordOperation) {
 					pages.SwitchToPage("Main")
 					currentPage = "Main"
 					pages.RemovePage("History")
 					if operation != nil {
-						app.State.Project.History.SetOperation(operation)
-						go app.State.Project.SaveHistory()
-						app.State.Project.ApplyHistory()
-						app.SearchKeyword(app.State.Temp.Keyword)
+						app.SetOperation(operation)
 						app.UI.SetFocus(tabPrimitives[currentPrimitive])
-						app.UpdateView()
 						app.UI.Draw()
 					}
+				}, func() {
+					branches := branchesList(app, pages, func(branch *HistoryBranch) {
+						pages.RemovePage("Branches")
+						if branch == nil {
+							pages.SwitchToPage("History")
+							currentPage = "History"
+							return
+						}
+						pages.RemovePage("History")
+						pages.SwitchToPage("Main")
+						currentPage = "Main"
+						app.SwitchBranch(branch)
+						app.UI.SetFocus(tabPrimitives[currentPrimitive])
+					})
+					pages.AddAndSwitchToPage("Branches", branches, true)
+					currentPage = "Branches"
 				})
 				pages.AddAndSwitchToPage("History", history, true)
 				currentPage = "History"
 				return tcell.NewEventKey(tcell.KeyNUL, 0, tcell.ModNone)
+			} else if event.Modifiers()&tcell.ModAlt != 0 && (event.Rune() == 'l' || event.Rune() == 'д') {
+				clusters := clustersPage(app, pages, func() {
+					pages.SwitchToPage("Main")
+					currentPage = "Main"
+					pages.RemovePage("Clusters")
+					app.UpdateView()
+					app.UI.SetFocus(tabPrimitives[currentPrimitive])
+				})
+				pages.AddAndSwitchToPage("Clusters", clusters, true)
+				currentPage = "Clusters"
+				return tcell.NewEventKey(tcell.KeyNUL, 0, tcell.ModNone)
+			} else if event.Modifiers()&tcell.ModAlt != 0 && (event.Rune() == 'k' || event.Rune() == 'л') {
+				categories := categoriesList(app, pages, func(path string, ok bool) {
+					pages.SwitchToPage("Main")
+					currentPage = "Main"
+					pages.RemovePage("Categories")
+					if ok {
+						app.State.Project.Category = path
+						if path == "" {
+							app.SetStatusBarText("Новые кластеры сохраняются без категории")
+						} else {
+							app.SetStatusBarText("Новые кластеры сохраняются в категорию:[yellow] " + tview.Escape(path))
+						}
+					}
+					app.UI.SetFocus(tabPrimitives[currentPrimitive])
+				})
+				pages.AddAndSwitchToPage("Categories", categories, true)
+				currentPage = "Categories"
+				return tcell.NewEventKey(tcell.KeyNUL, 0, tcell.ModNone)
 			}
 		}
 
@@ -221,31 +613,133 @@ func initPrimitives(app *App) (root *tview.Pages) {
 	return pages
 }
 
-func historyList(app *App, done func(operation *KeywordOperation)) *SimpleList {
+// columnsList toggles the metrics shown in the cluster tree, they are saved into the project config
+func columnsList(app *App, done func()) *SimpleList {
 	list := NewSimpleList()
-	list.SetBorder(true).SetTitle("История").SetBorderPadding(0, 0, 1, 1)
-	for i := len(app.State.Project.History.Operations) - 1; i >= 0; i-- {
-		index := i
-		oper := app.State.Project.History.Operations[i]
-		var color string = "[green]"
-		if oper.Operation != OperationAdd {
-			color = "[red]"
+	list.SetBorder(true).SetTitle("Колонки дерева кластеров").SetBorderPadding(0, 0, 1, 1)
+
+	var fill func()
+	fill = func() {
+		current := list.GetCurrentItem()
+		list.Clear()
+		config := app.State.Project.Config
+		for _, column := range treeColumns {
+			column := column
+			mark := "[ ]"
+			if containsString(config.TreeColumns, column) {
+				mark = "[green][x][white]"
+			}
+			list.AddItem(fmt.Sprintf("%s %s", mark, treeColumnName(column)), func() {
+				var columns []string
+				for _, c := range treeColumns {
+					if (c == column) != containsString(config.TreeColumns, c) {
+						columns = append(columns, c)
+					}
+				}
+				if columns == nil {
+					columns = []string{}
+				}
+				config.TreeColumns = columns
+				app.State.Project.SaveConfig()
+				app.Primitives.ClusterTree.SetColumns(columns)
+				fill()
+			})
 		}
-		var pointer string
-		if i == app.State.Project.History.CurrentStateIndex {
-			pointer = " <-- текущий"
+		list.SetCurrentItem(current)
+	}
+	fill()
+
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyEscape {
+			done()
 		}
-		var prefix string
-		if oper.Operation == OperationSilentRemove {
-			prefix = "-- "
+		return event
+	})
+	return list
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
 		}
-		list.AddItem(fmt.Sprintf("%s%v. %s%s [white]%s", color, i+1, prefix, oper.Keyword, pointer), func() {
-			done(app.State.Project.History.Operations[index])
-		})
 	}
+	return false
+}
+
+// Parameter branches opens the list of history branches.
+// Notes of operations are edited in a dialog page.
+func historyList(app *App, pages *tview.Pages, done func(operation *KeywordOperation), branches func()) *SimpleList {
+	history := app.State.Project.History
+	list := NewSimpleList()
+	title := "История"
+	if len(history.Branches) > 1 {
+		title += ", ветка " + history.Name
+	}
+	title += " (B — ветки, N — заметка, K — в текущую категорию)"
+	list.SetBorder(true).SetTitle(title).SetBorderPadding(0, 0, 1, 1)
+
+	fill := func() {
+		current := list.GetCurrentItem()
+		list.Clear()
+		for i := len(history.Operations) - 1; i >= 0; i-- {
+			index := i
+			oper := history.Operations[i]
+			var color string = "[green]"
+			if oper.Operation == OperationRestore || oper.Merge != "" {
+				color = "[yellow]"
+			} else if oper.Operation != OperationAdd {
+				color = "[red]"
+			}
+			var pointer string
+			if i == history.CurrentStateIndex {
+				pointer = " <-- текущий"
+			}
+			var prefix string
+			if oper.Operation == OperationSilentRemove {
+				prefix = "-- "
+			} else if oper.Operation == OperationRestore {
+				prefix = "< "
+			} else if oper.Merge != "" {
+				prefix = "» "
+			}
+			list.AddItem(fmt.Sprintf("%s%v. %s%s [white]%s%s", color, i+1, prefix, tview.Escape(operationTarget(oper)), pointer, operationDetails(oper)), func() {
+				done(history.Operations[index])
+			})
+		}
+		list.SetCurrentItem(current)
+	}
+	fill()
+
 	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 		if event.Key() == tcell.KeyEscape {
 			done(nil)
+		} else if event.Rune() == 'b' || event.Rune() == 'и' {
+			branches()
+			return nil
+		} else if (event.Rune() == 'n' || event.Rune() == 'т') && len(history.Operations) > 0 {
+			op := history.Operations[len(history.Operations)-1-list.GetCurrentItem()]
+			input := textInput("Заметка к операции", " Заметка: ", op.Note, func(note string, ok bool) {
+				if ok {
+					op.Note = note
+					app.State.Project.SaveHistory()
+					fill()
+				}
+				pages.RemovePage("HistoryDialog")
+				pages.SwitchToPage("History")
+				app.UI.SetFocus(list)
+			})
+			pages.AddAndSwitchToPage("HistoryDialog", input, true)
+			return nil
+		} else if (event.Rune() == 'k' || event.Rune() == 'л') && len(history.Operations) > 0 {
+			op := history.Operations[len(history.Operations)-1-list.GetCurrentItem()]
+			if err := app.State.Project.MoveCluster(op, app.State.Project.Category); err != nil {
+				list.SetTitle("[red]" + tview.Escape(err.Error()))
+			} else {
+				list.SetTitle(title)
+				fill()
+			}
+			return nil
 		}
 		return event
 	})
@@ -259,19 +753,86 @@ func showHelp() {
 	fmt.Println("  Запуск существующего проекта")
 	fmt.Println(" -p projects/spina -f csv/all.csv")
 	fmt.Println("  Создание проекта с указанием источника запросов")
+	fmt.Println(" -p projects/spina -f csv/wordstat.csv -f csv/keycollector.xlsx -dedup lemma -merge sum")
+	fmt.Println("  Создание проекта из нескольких файлов с удалением дублей")
+	fmt.Println(" -p projects/spina -append csv/new.csv")
+	fmt.Println("  Добавление новых запросов в существующий проект")
 	fmt.Println(" -p projects/spina -update")
 	fmt.Println("  Открытие проекта с пересохранением ключевых слов в файлы")
+	fmt.Println(" -p projects/spina -f csv/new.csv -apply rules.txt")
+	fmt.Println("  Создание проекта и применение правил без интерфейса")
 	fmt.Println("\nГде:")
 	fmt.Println(" \"-p\" — путь к проекту")
-	fmt.Println(" \"-f\" — путь к файлу с запросами, по которому создастся проект")
-	fmt.Println(" \"-update\" — комманда вырезать все ключевые слова из файла history.txt")
+	fmt.Println(" \"-f\" — путь к файлу с запросами, по которому создастся проект, можно указать несколько раз")
+	fmt.Println(" \"-append\" — путь к файлу с запросами, которые нужно добавить в проект, можно указать несколько раз")
+	fmt.Println("  К новым запросам применяется история проекта")
+	fmt.Println(" \"-dedup\" — удаление дублей: exact (по запросу, по умолчанию) или lemma (по набору лемм)")
+	fmt.Println(" \"-merge\" — частотность дублей: max (по умолчанию), sum или first (из первого файла)")
+	fmt.Println(" \"-update\" — комманда вырезать все ключевые слова из истории проекта")
+	fmt.Println(" \"-col-keyword\", \"-col-lemma\", \"-col-freq\", \"-col-strong-freq\" — названия колонок файла с запросами")
+	fmt.Println("  Если не указаны, определяются автоматически (Key Collector, Wordstat, Keyword Planner, Ahrefs, Semrush)")
+	fmt.Println("  Сохраняются в config.json проекта")
+	fmt.Println(" \"-normalizer\" — нормализатор запросов без леммы: stemmer (по умолчанию) или lower")
+	fmt.Println(" \"-delimiter\" — разделитель полей: \",\", \";\", \"tab\" или \"|\", по умолчанию определяется автоматически")
+	fmt.Println(" \"-encoding\" — кодировка: utf-8, cp1251, utf-16le, utf-16be, по умолчанию определяется автоматически")
+	fmt.Println("  Файлы .gz и .zip распаковываются автоматически")
+	fmt.Println(" \"-sheet\" — название или номер листа, если файл с запросами в формате .xlsx")
+	fmt.Println(" \"-cluster-format\" — формат файлов кластеров: csv (по умолчанию) или xlsx, сохраняется в config.json")
+	fmt.Println(" \"-workbook\" — выгрузить остаток и все кластеры в одну книгу .xlsx, по листу на кластер")
+	fmt.Println(" \"-apply\" — применить к проекту операции из файла правил и выйти")
+	fmt.Println("  Строки правил как в истории: \"+ запрос\", \"- запрос\", \"-- запрос\", комментарии начинаются с #")
+	fmt.Println()
+	fmt.Println("Команды без интерфейса (проект открывается только для чтения):")
+	fmt.Println(" stats -p projects/spina                  — число запросов, остаток, вырезано, история")
+	fmt.Println(" search -p projects/spina -limit 50 грыжа — запросы остатка по запросу, по убыванию частотности")
+	fmt.Println(" tree -p projects/spina -top 20 -depth 2 грыжа — подкластеры как в дереве")
+	fmt.Println(" history -p projects/spina -all           — операции активной ветки или всех веток")
+	fmt.Println(" export -p projects/spina -o out.xlsx грыжа — выгрузить остаток по запросу в csv/xlsx или в stdout")
+	fmt.Println(" structure -p projects/spina             — категории с кластерами, их запросами и частотностью")
+	fmt.Println(" \"-format json\" — вывод в JSON, \"-all\" в search и export — поиск по всем запросам проекта")
+	fmt.Println()
+	fmt.Println("Язык запросов (строка поиска и история):")
+	fmt.Println(" грыжа лечение            — оба слова")
+	fmt.Println(" грыжа | боль, грыжа OR боль — любое из слов")
+	fmt.Println(" -бесплатно               — без слова")
+	fmt.Println(" \"лечение грыжи\"          — точная фраза")
+	fmt.Println(" [грыжа позвоночник]      — слова в этом порядке")
+	fmt.Println(" лечен*                   — слова с началом")
+	fmt.Println(" freq>100, exact<=10, words=3 — частотность, строгая частотность, число слов")
+	fmt.Println(" (грыжа | боль) -бесплатно — группировка")
+	fmt.Println(" Дерево строится по мере ввода запроса, Enter — применить запрос сразу")
 	fmt.Println()
 	fmt.Println("Управление деревом кластеров:")
 	fmt.Println(" +             — добавить кластер")
 	fmt.Println(" Backspace/-   — удалить кластер")
 	fmt.Println(" Ctrl+D        — удалить рутовый кластер")
 	fmt.Println(" Ctrl+S        — сохранить рутовый кластер")
+	fmt.Println(" >             — сохранить кластер в кластер с названием, запросы добавляются в его файл")
 	fmt.Println(" /             — удалить рутовый кластер без вырезания")
+	fmt.Println(" !             — добавить слово кластера в стоп-слова, оно не показывается в дереве")
+	fmt.Println(" Ctrl+Z        — отменить последнюю операцию")
+	fmt.Println(" Ctrl+Y        — повторить отмененную операцию")
+	fmt.Println(" Alt+H         — история, N в истории — заметка к операции")
+	fmt.Println("                 B в истории — ветки: переключение, переименование (R), сравнение (C)")
+	fmt.Println(" Alt+O         — переключить сортировку: по количеству запросов, частотности, строгой частотности, алфавиту")
+	fmt.Println(" Alt+= / Alt+- — увеличить / уменьшить минимальный размер кластера")
+	fmt.Println(" Alt+S         — операции вырезают слово вместе с синонимами или только слово")
+	fmt.Println(" Alt+L         — сохраненные и удаленные кластеры: Enter — запросы кластера, R — вернуть кластер")
+	fmt.Println("                 или выбранные запросы в остаток, M — объединить с другим кластером")
+	fmt.Println(" Alt+K         — категории: Enter — сохранять новые кластеры в категорию, N — новая, R — переименовать,")
+	fmt.Println("                 M — переместить, D — удалить пустую; K в истории — перенести кластер в текущую категорию")
+	fmt.Println(" Alt+C         — выбрать показатели рядом с кластерами: количество запросов, частотность, доля, число слов")
+	fmt.Println(" Настройки дерева сохраняются в config.json проекта")
+	fmt.Println()
+	fmt.Println("Управление списком запросов:")
+	fmt.Println(" Пробел        — выбрать запрос")
+	fmt.Println(" Shift+↑/↓     — выбрать диапазон")
+	fmt.Println(" Ctrl+A        — выбрать все запросы или снять выбор, Esc — снять выбор")
+	fmt.Println(" +             — перенести выбранные запросы в кластер с названием")
+	fmt.Println(" -, Delete     — удалить выбранные запросы в removed")
+	fmt.Println(" /             — удалить выбранные запросы без извлечения в файл")
+	fmt.Println(" X             — сохранить текущий кластер без выбранных запросов, они остаются в остатке")
+	fmt.Println(" Без выбора операции применяются к запросу под курсором")
 	fmt.Println()
 }
 
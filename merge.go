@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Keys of keyword deduplication
+const (
+	DedupExact = "exact" // the same words in the same order, case-insensitive
+	DedupLemma = "lemma" // the same set of lemmas
+)
+
+// Rules of frequency merging for duplicates
+const (
+	MergeMax   = "max"
+	MergeSum   = "sum"
+	MergeFirst = "first" // the first source wins
+)
+
+// MergeOptions describes how rows of several sources are combined
+type MergeOptions struct {
+	Dedup     string
+	Frequency string
+}
+
+func (o MergeOptions) Validate() error {
+	switch o.Dedup {
+	case "", DedupExact, DedupLemma:
+	default:
+		return fmt.Errorf("unknown deduplication \"%s\", available: exact, lemma", o.Dedup)
+	}
+	switch o.Frequency {
+	case "", MergeMax, MergeSum, MergeFirst:
+	default:
+		return fmt.Errorf("unknown frequency rule \"%s\", available: max, sum, first", o.Frequency)
+	}
+	return nil
+}
+
+// mergeRows deduplicates the added rows against the existing ones and each other.
+// The existing rows are all kept and never changed: frequencies of their duplicates are merged
+// into copies, which are applied by applyFrequencies. Frequencies of duplicates of the new rows
+// are merged into them. It returns all rows, the rows which are really new and the copies
+// of the existing rows whose frequencies are changed.
+func mergeRows(existing, added []*Row, options MergeOptions) (merged, newRows []*Row, updated map[*Row]*Row) {
+	existingRows := make(map[string]*Row, len(existing))
+	merged = make([]*Row, 0, len(existing)+len(added))
+	for _, row := range existing {
+		key := dedupKey(row, options.Dedup)
+		if _, ok := existingRows[key]; !ok {
+			existingRows[key] = row
+		}
+		merged = append(merged, row)
+	}
+	addedRows := make(map[string]*Row, len(added))
+	updated = make(map[*Row]*Row)
+	for _, row := range added {
+		key := dedupKey(row, options.Dedup)
+		if kept, ok := existingRows[key]; ok {
+			copied, ok := updated[kept]
+			if !ok {
+				value := *kept
+				copied = &value
+			}
+			if mergeFrequency(copied, row, options.Frequency) {
+				updated[kept] = copied
+			}
+			continue
+		}
+		if kept, ok := addedRows[key]; ok {
+			mergeFrequency(kept, row, options.Frequency)
+			continue
+		}
+		addedRows[key] = row
+		merged = append(merged, row)
+		newRows = append(newRows, row)
+	}
+	return
+}
+
+// applyFrequencies sets the merged frequencies of the copies to the rows and returns the changed rows
+func applyFrequencies(updated map[*Row]*Row) []*Row {
+	rows := make([]*Row, 0, len(updated))
+	for row, copied := range updated {
+		row.Frequency, row.StrongFrequency = copied.Frequency, copied.StrongFrequency
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func dedupKey(row *Row, dedup string) string {
+	if dedup == DedupLemma {
+		words := strings.Fields(row.NormalizedKeyword)
+		sort.Strings(words)
+		return strings.Join(words, " ")
+	}
+	return strings.ToLower(strings.Join(strings.Fields(row.Keyword), " "))
+}
+
+// mergeFrequency merges the frequencies of the duplicate into the kept row and reports whether they are changed
+func mergeFrequency(kept, duplicate *Row, rule string) bool {
+	frequency, strong := kept.Frequency, kept.StrongFrequency
+	switch rule {
+	case MergeSum:
+		kept.Frequency += duplicate.Frequency
+		kept.StrongFrequency += duplicate.StrongFrequency
+	case MergeFirst:
+	default:
+		if duplicate.Frequency > kept.Frequency {
+			kept.Frequency = duplicate.Frequency
+		}
+		if duplicate.StrongFrequency > kept.StrongFrequency {
+			kept.StrongFrequency = duplicate.StrongFrequency
+		}
+	}
+	return kept.Frequency != frequency || kept.StrongFrequency != strong
+}
+
+// loadSources reads and merges all source files
+func loadSources(files []string, options SourceOptions, merge MergeOptions, normalizer Normalizer) ([]*Row, error) {
+	var rows []*Row
+	for _, file := range files {
+		fileRows, err := LoadRows(file, options)
+		if err != nil {
+			return nil, err
+		}
+		normalizeRows(fileRows, normalizer)
+		// Duplicates of a single source are merged too
+		var updated map[*Row]*Row
+		rows, _, updated = mergeRows(rows, fileRows, merge)
+		applyFrequencies(updated)
+	}
+	return rows, nil
+}
+
+// AppendSource adds the rows of the file which are not in the project yet.
+// The applied history operations are replayed against the new rows,
+// so their cluster files are extended and the rest of the rows is added to the remains.
+// The project is changed and written only when the history is replayed.
+func (p *Project) AppendSource(file string, options SourceOptions, merge MergeOptions) error {
+	// Queued writes read the rows whose frequencies are merged
+	if err := p.flush(); err != nil {
+		return err
+	}
+	rows, err := LoadRows(file, options)
+	if err != nil {
+		return err
+	}
+	normalizeRows(rows, p.Normalizer)
+
+	initialRows, added, updated := mergeRows(p.InitialRows, rows, merge)
+	fmt.Printf("%d of %d keywords are new\n", len(added), len(rows))
+	remaining, cuts, err := p.replayHistory(added)
+	if err != nil {
+		return err
+	}
+
+	p.InitialRows = initialRows
+	// Cluster files of the rows whose frequencies are merged are written again
+	operations := p.rowOperations(applyFrequencies(updated))
+	for _, op := range p.History.Operations {
+		if cut, ok := cuts[op]; ok {
+			op.setRows(append(op.rows, cut...))
+			operations = append(operations, op)
+		}
+	}
+	p.Index.Add(added)
+	p.Index.Remove(removeRowsFrom(added, remaining))
+	p.Rows = p.Index.Rows()
+
+	p.SaveRows(p.InitialRows, p.Paths.OriginalFile)
+	if len(operations) > 0 {
+		p.SaveClusterFiles(operations)
+	}
+	p.Save()
+	return nil
+}
+
+// rowOperations returns the applied operations which cut any of the rows
+func (p *Project) rowOperations(rows []*Row) []*KeywordOperation {
+	var operations []*KeywordOperation
+	set := convertRowsToMap(rows)
+	for _, op := range p.History.Operations[:p.History.CurrentStateIndex+1] {
+		// Restored rows are not in the cluster files
+		if op.Operation == OperationRestore {
+			continue
+		}
+		for _, row := range op.rows {
+			if _, ok := set[row]; ok {
+				operations = append(operations, op)
+				break
+			}
+		}
+	}
+	return operations
+}
+
+// replayHistory cuts the rows by the applied operations. It returns the rows which are left
+// and the cut rows of every operation, the operations and their files are not changed.
+func (p *Project) replayHistory(rows []*Row) ([]*Row, map[*KeywordOperation][]*Row, error) {
+	cuts := make(map[*KeywordOperation][]*Row)
+	for i, op := range p.History.Operations {
+		if i > p.History.CurrentStateIndex || len(rows) == 0 {
+			break
+		}
+		// Restored and merged keywords were in the project before, so the new rows are never restored or merged
+		if op.Operation == OperationRestore || op.Merge != "" {
+			continue
+		}
+		var cut []*Row
+		if op.Keywords != nil {
+			cut = selectKeywordRows(rows, op.Keywords)
+		} else {
+			var err error
+			if cut, err = selectRows(op.Keyword, rows, p.Normalizer, op.querySynonyms(p.Index)); err != nil {
+				return nil, nil, err
+			}
+		}
+		if len(cut) == 0 {
+			continue
+		}
+		rows = removeRowsFrom(rows, cut)
+		cuts[op] = cut
+	}
+	return rows, cuts, nil
+}
+
+// removeRowsFrom returns the rows without the removed ones keeping their order
+func removeRowsFrom(rows []*Row, removed []*Row) []*Row {
+	removedMap := convertRowsToMap(removed)
+	left := make([]*Row, 0, len(rows))
+	for _, row := range rows {
+		if _, ok := removedMap[row]; !ok {
+			left = append(left, row)
+		}
+	}
+	return left
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const DefaultNormalizer = "stemmer"
+
+// Normalizer converts a keyword into the lemmatized form which is used for clustering
+type Normalizer interface {
+	Normalize(keyword string) string
+}
+
+// Registered normalizers by their names.
+// The name is stored in the project config.
+var normalizers = map[string]func() Normalizer{
+	"stemmer": func() Normalizer { return StemNormalizer{} },
+	"lower":   func() Normalizer { return LowerNormalizer{} },
+}
+
+func NewNormalizer(name string) (Normalizer, error) {
+	if name == "" {
+		name = DefaultNormalizer
+	}
+	constructor, ok := normalizers[name]
+	if !ok {
+		var names []string
+		for n := range normalizers {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown normalizer \"%s\", available: %s", name, strings.Join(names, ", "))
+	}
+	return constructor(), nil
+}
+
+// StemNormalizer stems Russian and English words with Snowball stemmers.
+// Words of other alphabets and numbers are only lowercased.
+type StemNormalizer struct{}
+
+func (StemNormalizer) Normalize(keyword string) string {
+	words := splitWords(keyword)
+	for i, word := range words {
+		switch wordAlphabet(word) {
+		case alphabetCyrillic:
+			words[i] = stemRussian(word)
+		case alphabetLatin:
+			words[i] = stemEnglish(word)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// LowerNormalizer keeps the word forms as is
+type LowerNormalizer struct{}
+
+func (LowerNormalizer) Normalize(keyword string) string {
+	return strings.Join(splitWords(keyword), " ")
+}
+
+// normalizeRows fills the empty lemmas of rows
+func normalizeRows(rows []*Row, normalizer Normalizer) {
+	for _, row := range rows {
+		if strings.TrimSpace(row.NormalizedKeyword) == "" {
+			row.NormalizedKeyword = normalizer.Normalize(row.Keyword)
+		}
+	}
+}
+
+// splitWords returns lowercased words of the text without punctuation
+func splitWords(text string) []string {
+	text = strings.ToLower(text)
+	text = strings.Replace(text, "ё", "е", -1)
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\'' && r != '-'
+	})
+}
+
+const (
+	alphabetOther = iota
+	alphabetCyrillic
+	alphabetLatin
+)
+
+// wordAlphabet detects the alphabet of a word, mixed words are not stemmed
+func wordAlphabet(word string) int {
+	alphabet := alphabetOther
+	for _, r := range word {
+		var current int
+		switch {
+		case unicode.Is(unicode.Cyrillic, r):
+			current = alphabetCyrillic
+		case r >= 'a' && r <= 'z':
+			current = alphabetLatin
+		case r == '\'' || r == '-':
+			continue
+		default:
+			return alphabetOther
+		}
+		if alphabet != alphabetOther && alphabet != current {
+			return alphabetOther
+		}
+		alphabet = current
+	}
+	return alphabet
+}
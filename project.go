@@ -4,28 +4,48 @@ import (
 	"fmt"
 	"github.com/jszwec/csvutil"
 	"gopkg.in/cheggaaa/pb.v2"
-	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
-	"sync"
+	"time"
 )
 
 const (
 	ProjectRemainFile   = "remains.csv"
 	ProjectOriginalFile = "original.csv"
-	ProjectHistoryFile  = "history.txt"
+	ProjectHistoryFile  = "history.jsonl"
+	ProjectConfigFile   = "config.json"
 	ProjectClustersDir  = "clusters"
 	ProjectRemovedDir   = "removed"
+
+	// Category tree of the clusters
+	ProjectCategoriesFile = "categories.json"
+
+	// Text history of the first version, it's migrated on load
+	ProjectLegacyHistoryFile = "history.txt"
 )
 
 type Project struct {
 	Rows        []*Row
 	InitialRows []*Row
-
-	History *History
-	Paths   ProjectPaths
+	// Index of the initial rows, the rows which are cut are marked as removed
+	Index *WordIndex
+
+	History    *History
+	Config     *ProjectConfig
+	Normalizer Normalizer
+	Paths      ProjectPaths
+
+	// Tree of the categories of the clusters
+	Categories []*Category
+	// Path of the category which new clusters are cut into, empty for the root
+	Category string
+
+	// Files of the project are written in the background one by one
+	writer *projectWriter
+	// Lock of the project directory, it's released by Close
+	lock *os.File
 }
 
 type ProjectPaths struct {
@@ -33,183 +53,618 @@ type ProjectPaths struct {
 	OriginalFile string
 	RemainsFile  string
 	HistoryFile  string
+	ConfigFile   string
 	ClustersDir  string
 	RemovedDir   string
+	// Category tree, the clusters of a category are in its directory inside ClustersDir
+	CategoriesFile string
+
+	// History of the first version which is migrated to HistoryFile
+	LegacyHistoryFile string
 }
 
-func CreateProject(path, csvFile string, createKeywordFiles bool) *Project {
+// The options describe the format of source files, empty fields are auto-detected.
+// Rows without lemma are normalized by the normalizer with the passed name.
+// Rows of several files are deduplicated according to the merge options.
+// The original file of the project is stored as comma-separated UTF-8 csv with canonical headers.
+func CreateProject(path string, files []string, options SourceOptions, merge MergeOptions, createKeywordFiles bool) (*Project, error) {
 	if _, err := os.Stat(path); !os.IsNotExist(err) {
-		return LoadProject(path, createKeywordFiles)
+		return LoadProject(path, options, createKeywordFiles)
 	}
 
-	paths := resolveProjectPaths(path)
-	err := os.MkdirAll(paths.Dir, 0777)
-	check(err)
-	err = copyFile(csvFile, paths.OriginalFile)
-	check(err)
-	err = copyFile(csvFile, paths.RemainsFile)
-	check(err)
-
-	rows := LoadRows(paths.OriginalFile)
+	paths, err := resolveProjectPaths(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(paths.Dir, 0777); err != nil {
+		return nil, err
+	}
+	lock, err := lockProject(paths.Dir)
+	if err != nil {
+		return nil, err
+	}
+	project := &Project{Paths: *paths, History: NewHistory(), writer: newProjectWriter(), lock: lock}
+	if err := project.create(files, options, merge); err != nil {
+		project.Close()
+		return nil, err
+	}
+	return project, nil
+}
 
-	return &Project{
-		Rows:        rows,
-		InitialRows: rows,
-		Paths:       *paths,
-		History:     &History{},
+// create reads the sources and writes the files of the new project
+func (p *Project) create(files []string, options SourceOptions, merge MergeOptions) error {
+	config := &ProjectConfig{Columns: options.Columns, Normalizer: options.Normalizer}
+	config.setDefaults()
+	norm, err := NewNormalizer(config.Normalizer)
+	if err != nil {
+		return err
+	}
+	rows, err := loadSources(files, options, merge, norm)
+	if err != nil {
+		return err
+	}
+	if err := SaveRows(rows, p.Paths.OriginalFile); err != nil {
+		return err
+	}
+	if err := SaveRows(rows, p.Paths.RemainsFile); err != nil {
+		return err
 	}
+	if err := config.Save(p.Paths.ConfigFile); err != nil {
+		return err
+	}
+
+	p.Rows = rows
+	p.InitialRows = rows
+	p.Index = NewWordIndex(rows)
+	p.Config = config
+	p.Normalizer = norm
+	p.UpdateStopWords()
+	p.UpdateSynonyms()
+	return nil
 }
 
 // Create and return reference to project structure
-// Parameter bar can be nil
-func LoadProject(path string, createHistoryFiles bool) *Project {
-	_, err := os.Stat(path)
-	if os.IsNotExist(err) {
-		panic("Project does not exist")
+// Non-empty columns and normalizer of the options override the settings stored in the project config
+func LoadProject(path string, options SourceOptions, createHistoryFiles bool) (*Project, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, fmt.Errorf("project %s does not exist", path)
 	}
 
-	project := Project{}
-	project.Paths = *resolveProjectPaths(path)
-	project.InitialRows = LoadRows(project.Paths.OriginalFile)
-	project.History = LoadHistory(project.Paths.HistoryFile)
-	//if createHistoryFiles {
-	//	project.History.CurrentStateIndex = len(project.History.Operations) - 1
-	//}
+	paths, err := resolveProjectPaths(path)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := lockProject(paths.Dir)
+	if err != nil {
+		return nil, err
+	}
+	project := &Project{Paths: *paths, writer: newProjectWriter(), lock: lock}
+	if err := project.load(options, createHistoryFiles); err != nil {
+		project.Close()
+		return nil, err
+	}
+	return project, nil
+}
+
+// LoadProjectReadOnly quietly loads the project to inspect it. The project is not locked
+// and its files are never written, so it can be opened by another instance at the same time.
+func LoadProjectReadOnly(path string) (*Project, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, fmt.Errorf("project %s does not exist", path)
+	}
+
+	paths, err := resolveProjectPaths(path)
+	if err != nil {
+		return nil, err
+	}
+	project := &Project{Paths: *paths}
+	if err := project.load(SourceOptions{}, false); err != nil {
+		return nil, err
+	}
+	return project, nil
+}
+
+// readOnly checks whether the project is loaded by LoadProjectReadOnly
+func (p *Project) readOnly() bool {
+	return p.writer == nil
+}
+
+// load reads the files of the project and applies its history
+func (p *Project) load(options SourceOptions, createHistoryFiles bool) error {
+	var err error
+	if p.Config, err = LoadProjectConfig(p.Paths.ConfigFile); err != nil {
+		return err
+	}
+	if options.Columns != (ColumnMapping{}) || options.Normalizer != "" {
+		p.Config.Columns = p.Config.Columns.Merge(options.Columns)
+		if options.Normalizer != "" {
+			p.Config.Normalizer = options.Normalizer
+		}
+		if err := p.Config.Save(p.Paths.ConfigFile); err != nil {
+			return err
+		}
+	}
+	if p.Normalizer, err = NewNormalizer(p.Config.Normalizer); err != nil {
+		return &FileError{File: p.Paths.ConfigFile, Err: err}
+	}
+	if p.Categories, err = LoadCategories(p.Paths.CategoriesFile); err != nil {
+		return err
+	}
+	// The original file is already stored with canonical headers
+	if p.readOnly() {
+		if p.InitialRows, err = ReadRows(p.Paths.OriginalFile); err != nil {
+			return fmt.Errorf("can't read %s: %v", p.Paths.OriginalFile, err)
+		}
+	} else if p.InitialRows, err = LoadRows(p.Paths.OriginalFile, SourceOptions{}); err != nil {
+		return err
+	}
+	normalizeRows(p.InitialRows, p.Normalizer)
+	if p.History, err = p.loadHistory(); err != nil {
+		return err
+	}
 
+	p.Index = NewWordIndex(p.InitialRows)
+	p.UpdateStopWords()
+	p.UpdateSynonyms()
 	if createHistoryFiles {
-		project.Rows = project.SaveAndApplyOperationKeywords()
+		err = p.SaveAndApplyOperationKeywords()
 	} else {
-		project.Rows = ApplyHistory(project.InitialRows, project.History)
+		err = applyHistory(p.Index, p.History, p.Normalizer)
+	}
+	if err != nil {
+		return err
+	}
+	p.Rows = p.Index.Rows()
+	p.Save()
+	return nil
+}
+
+// loadHistory reads the history file. The text history of the first version is converted
+// into the current format and kept as a backup.
+func (p *Project) loadHistory() (*History, error) {
+	if _, err := os.Stat(p.Paths.HistoryFile); err == nil || !os.IsNotExist(err) {
+		return LoadHistory(p.Paths.HistoryFile)
+	}
+	if _, err := os.Stat(p.Paths.LegacyHistoryFile); err != nil {
+		return NewHistory(), nil
 	}
-	go project.Save()
 
-	return &project
+	history, err := LoadHistory(p.Paths.LegacyHistoryFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, branch := range history.Branches {
+		for _, op := range branch.Operations {
+			op.File = p.relativePath(p.ClusterPath(op.Category, op.ClusterName(), op.Operation))
+		}
+	}
+	if p.readOnly() {
+		return history, nil
+	}
+	if err := history.Save(p.Paths.HistoryFile); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(p.Paths.LegacyHistoryFile, p.Paths.LegacyHistoryFile+".bak"); err != nil {
+		return nil, err
+	}
+	fmt.Printf("History is migrated to %s\n", ProjectHistoryFile)
+	return history, nil
 }
 
-func (p *Project) Save() {
-	mutex := &sync.Mutex{}
-	data, err := csvutil.Marshal(p.Rows[:])
-	check(err)
-	mutex.Lock()
-	err = ioutil.WriteFile(p.Paths.RemainsFile, data, 0777)
-	check(err)
+// relativePath returns the path relative to the project directory
+func (p *Project) relativePath(path string) string {
+	if path == "" {
+		return ""
+	}
+	if rel, err := filepath.Rel(p.Paths.Dir, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
 
-	p.History.Save(p.Paths.HistoryFile)
-	mutex.Unlock()
-	check(err)
+// Save queues writing of the remaining rows and the history
+func (p *Project) Save() {
+	rows := p.Rows
+	history, err := p.History.encode()
+	p.write(func() error {
+		if err != nil {
+			return err
+		}
+		if err := SaveRows(rows, p.Paths.RemainsFile); err != nil {
+			return err
+		}
+		if history == nil {
+			return nil
+		}
+		return writeFileDataAtomic(p.Paths.HistoryFile, history)
+	})
 }
 
+// SaveHistory queues writing of the history
 func (p *Project) SaveHistory() {
-	mutex := &sync.Mutex{}
-	mutex.Lock()
-	p.History.Save(p.Paths.HistoryFile)
-	mutex.Unlock()
+	history, err := p.History.encode()
+	p.write(func() error {
+		if err != nil || history == nil {
+			return err
+		}
+		return writeFileDataAtomic(p.Paths.HistoryFile, history)
+	})
+}
+
+// SaveConfig queues writing of the config
+func (p *Project) SaveConfig() {
+	data, err := p.Config.encode()
+	p.write(func() error {
+		if err != nil {
+			return err
+		}
+		return writeFileDataAtomic(p.Paths.ConfigFile, data)
+	})
+}
+
+// SaveRows queues writing of the rows into the file
+func (p *Project) SaveRows(rows []*Row, path string) {
+	p.write(func() error {
+		return SaveRows(rows, path)
+	})
+}
+
+// removeFile queues deletion of the file, a missing file is not an error
+func (p *Project) removeFile(path string) {
+	p.write(func() error {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+// write queues the task of the writer, a read-only project is never written
+func (p *Project) write(task func() error) {
+	if !p.readOnly() {
+		p.writer.Do(task)
+	}
+}
+
+// flush waits for the queued writes and returns the first error of them
+func (p *Project) flush() error {
+	if p.readOnly() {
+		return nil
+	}
+	return p.writer.Wait()
+}
+
+// Close waits for the queued writes, stops the writer and releases the lock of the project directory.
+// It returns the first error of the writes.
+func (p *Project) Close() error {
+	if p.readOnly() {
+		return nil
+	}
+	err := p.flush()
+	p.writer.Close()
+	if lockErr := p.lock.Close(); err == nil {
+		err = lockErr
+	}
+	return err
+}
+
+// ProcessOperation cuts the rows into the cluster file of the operation and adds it to the history.
+// The file keeps the rows of all applied operations with it, so the rows are appended to an existing cluster.
+func (p *Project) ProcessOperation(keyword string, rows []*Row, operation int) (*KeywordOperation, error) {
+	return p.ProcessOperationInto(keyword, "", rows, operation)
+}
+
+// ProcessOperationInto is ProcessOperation which cuts the rows of the query into the cluster with the name.
+// The file of the operation is named by the query if the cluster name is empty.
+func (p *Project) ProcessOperationInto(keyword, cluster string, rows []*Row, operation int) (*KeywordOperation, error) {
+	switch operation {
+	case OperationAdd, OperationRemove, OperationSilentRemove:
+	default:
+		return nil, fmt.Errorf("operation %d can't cut keywords", operation)
+	}
+	name := cluster
+	if name == "" {
+		name = keyword
+	}
+	var category string
+	if operation == OperationAdd {
+		category = p.Category
+	}
+	path := p.ClusterPath(category, name, operation)
+
+	p.RemoveRows(rows)
+	op := p.History.AddOperation(keyword, operation)
+	op.setRows(rows)
+	op.Time = time.Now()
+	op.File = p.relativePath(path)
+	op.Cluster = cluster
+	op.Category = category
+	if op.Synonyms = p.expandSynonyms(); op.Synonyms {
+		// The query is valid, its rows are selected already
+		op.SynonymGroups, _ = querySynonymGroups(keyword, p.Normalizer, p.Index.Synonyms())
+	}
+	p.SaveClusterFiles([]*KeywordOperation{op})
+	return op, nil
+}
+
+// ProcessRowsOperation cuts the rows into the cluster with the name and adds a row operation
+// to the history. The operation keeps the keywords of the rows instead of a query,
+// so it cuts exactly them when the history is applied again.
+func (p *Project) ProcessRowsOperation(name string, rows []*Row, operation int) (*KeywordOperation, error) {
+	op, err := p.ProcessOperation(name, rows, operation)
+	if err != nil {
+		return nil, err
+	}
+	op.Synonyms = false
+	op.SynonymGroups = nil
+	op.Keywords = make([]string, len(rows))
+	for i, row := range rows {
+		op.Keywords[i] = row.Keyword
+	}
+	return op, nil
 }
 
 func (p *Project) RemoveRows(rows []*Row) {
-	rowMap := convertRowsToMap(p.Rows)
+	p.Index.Remove(rows)
+	p.Rows = p.Index.Rows()
+}
 
-	for i := range rows {
-		delete(rowMap, rows[i])
+// Undo restores the rows of the last applied operation and returns it, nil if nothing is applied.
+// The cluster file of the operation is deleted. Rows of a restore operation are cut
+// into their clusters again, rows of a merge operation are moved back into the merged cluster.
+func (p *Project) Undo() *KeywordOperation {
+	op := p.History.Undo()
+	if op == nil {
+		return nil
 	}
+	if op.Operation == OperationRestore {
+		op.returnRows()
+		p.Index.Remove(op.rows)
+	} else if op.Merge != "" {
+		op.returnRows()
+	} else {
+		p.Index.Restore(op.rows)
+	}
+	p.Rows = p.Index.Rows()
+	p.SaveClusterFiles(withSourceOperations([]*KeywordOperation{op}))
+	return op
+}
 
-	p.Rows = convertMapToRows(rowMap)
+// Redo cuts the rows of the next operation and returns it, nil if all operations are applied.
+// The cluster file of the operation is written again.
+func (p *Project) Redo() (*KeywordOperation, error) {
+	op := p.History.Redo()
+	if op == nil {
+		return nil, nil
+	}
+	previous := p.History.Operations[:p.History.CurrentStateIndex]
+	if err := applyOperation(p.Index, previous, op, p.Normalizer); err != nil {
+		p.History.Undo()
+		return nil, err
+	}
+	p.Rows = p.Index.Rows()
+	p.SaveClusterFiles(withSourceOperations([]*KeywordOperation{op}))
+	return op, nil
 }
 
+// SwitchBranch makes the branch active. Operations of the active branch are undone
+// down to the fork and the operations of the branch are redone up to its current state.
+func (p *Project) SwitchBranch(branch *HistoryBranch) error {
+	active := p.History.HistoryBranch
+	if branch == active {
+		return nil
+	}
+	// Operations from this index are undone, the rest are shared by the branches
+	stop := CommonOperations(active, branch)
+	if branch.CurrentStateIndex+1 < stop {
+		stop = branch.CurrentStateIndex + 1
+	}
+	state := active.CurrentStateIndex
+	for p.History.CurrentStateIndex >= stop {
+		p.Undo()
+	}
+	reached := active.CurrentStateIndex
+	active.CurrentStateIndex = state
+
+	target := branch.CurrentStateIndex
+	branch.CurrentStateIndex = reached
+	p.History.HistoryBranch = branch
+	for p.History.CurrentStateIndex < target {
+		if _, err := p.Redo(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+func (p *Project) ApplyHistory() error {
+	p.Index.Reset()
+	err := applyHistory(p.Index, p.History, p.Normalizer)
+	p.Rows = p.Index.Rows()
+	return err
+}
 
-func (p *Project) ApplyHistory() {
-	rows := p.InitialRows
-	p.Rows = ApplyHistory(rows, p.History)
+// SetOperation restores the state of the history after the operation.
+// Only the cluster files of the operations between the old and the new state are changed.
+func (p *Project) SetOperation(operation *KeywordOperation) error {
+	from := p.History.CurrentStateIndex
+	p.History.SetOperation(operation)
+	to := p.History.CurrentStateIndex
+	if from > to {
+		from, to = to, from
+	}
+	if err := p.ApplyHistory(); err != nil {
+		return err
+	}
+	p.SaveClusterFiles(withSourceOperations(p.History.Operations[from+1 : to+1]))
+	return nil
 }
 
-func (p *Project) SaveAndApplyOperationKeywords() []*Row {
-	wordMap := generateWordMap(p.InitialRows)
-	rowMap := convertRowsToMap(p.InitialRows)
+// SaveAndApplyOperationKeywords cuts the rows of the applied operations from the index
+// and rewrites the cluster files, so they match the current state of the history.
+// Every operation cuts only the rows which are not cut by the previous ones.
+func (p *Project) SaveAndApplyOperationKeywords() error {
+	p.Index.Reset()
 
 	fmt.Println("Cutting operations...")
-	bar := pb.StartNew(len(p.History.Operations))
-	for opIndex, op := range p.History.Operations {
+	bar := pb.StartNew(p.History.CurrentStateIndex + 1)
+	for i, op := range p.History.Operations[:p.History.CurrentStateIndex+1] {
+		bar.Increment()
+		if strings.TrimSpace(op.Keyword) == "" {
+			op.setRows(nil)
+			continue
+		}
+
 		// Rows with the current operation keyword
-		var operatedRows []*Row
-		keywordWords := strings.Fields(op.Keyword)
-		if len(keywordWords) == 0 {
+		if err := applyOperation(p.Index, p.History.Operations[:i], op, p.Normalizer); err != nil {
+			bar.Finish()
+			return err
+		}
+	}
+	bar.Finish()
+	p.Rows = p.Index.Rows()
+
+	p.SaveClusterFiles(nil)
+	return nil
+}
+
+// withSourceOperations adds the operations which the restore and merge operations took rows from,
+// so their cluster files are written too
+func withSourceOperations(operations []*KeywordOperation) []*KeywordOperation {
+	ret := append([]*KeywordOperation(nil), operations...)
+	for _, op := range operations {
+		if op.Operation == OperationRestore || op.Merge != "" {
+			ret = append(ret, op.sourceOperations()...)
+		}
+	}
+	return ret
+}
+
+// SaveClusterFiles makes the cluster files of the operations match the current state of the history:
+// files of the applied operations are written and files of the rest are deleted.
+// Clusters whose rows are all restored or merged into other ones have no file.
+// Nil operations means the operations of all branches.
+func (p *Project) SaveClusterFiles(operations []*KeywordOperation) {
+	if operations == nil {
+		for _, branch := range p.History.Branches {
+			operations = append(operations, branch.Operations...)
+		}
+	}
+
+	// Rows of the applied operations by their files, different queries can have the same file
+	applied := make(map[string][]*Row)
+	for _, op := range p.History.Operations[:p.History.CurrentStateIndex+1] {
+		if path := p.ClusterPath(op.Category, op.ClusterName(), op.Operation); path != "" {
+			applied[path] = append(applied[path], op.rows...)
+		}
+	}
+
+	saved := make(map[string]bool)
+	for _, op := range operations {
+		path := p.ClusterPath(op.Category, op.ClusterName(), op.Operation)
+		if path == "" || saved[path] {
 			continue
 		}
+		saved[path] = true
+		if rows := applied[path]; len(rows) > 0 {
+			p.SaveRows(rows, path)
+		} else {
+			p.removeFile(path)
+		}
+	}
+}
 
-		// Row search optimization
-		firstWord := keywordWords[0]
-		if keywordRows, ok := wordMap[firstWord]; ok {
-			var leftRows []*Row
-			for i, row := range keywordRows {
-				rowWords := strings.Fields(row.NormalizedKeyword)
-				if contains(rowWords, keywordWords[1:]) {
-					operatedRows = append(operatedRows, keywordRows[i])
-					if opIndex <= p.History.CurrentStateIndex  {
-						delete(rowMap, keywordRows[i])
-					}
-				} else {
-					leftRows = append(leftRows, keywordRows[i])
-				}
-			}
-			wordMap[firstWord] = leftRows
+// Select returns the rows of the project which match the query of any syntax
+func (p *Project) Select(query string) ([]*Row, error) {
+	return selectIndexRows(p.Index, query, p.Normalizer, p.querySynonyms(p.expandSynonyms()))
+}
+
+// RootCluster returns the cluster of the rows which match the query, all rows for an empty query
+func (p *Project) RootCluster(query string) (*Cluster, error) {
+	rows := p.Rows
+	if query != "" {
+		var err error
+		if rows, err = p.Select(query); err != nil {
+			return nil, err
 		}
+	}
+	root := NewCluster(query, rows, nil)
+	root.Index = p.Index
+	return root, nil
+}
 
-		switch op.Operation {
-		case OperationAdd:
-			SaveRows(operatedRows, filepath.Join(p.Paths.ClustersDir, op.Keyword+".csv"))
-		case OperationRemove:
-			SaveRows(operatedRows, filepath.Join(p.Paths.RemovedDir, op.Keyword+".csv"))
+// ClusterNames returns the sorted names of the clusters of the applied operations
+// which add rows into the category
+func (p *Project) ClusterNames(category string) []string {
+	var names []string
+	for _, op := range p.History.Operations[:p.History.CurrentStateIndex+1] {
+		if op.Operation == OperationAdd && op.Category == category && !containsString(names, op.ClusterName()) {
+			names = append(names, op.ClusterName())
 		}
-		bar.Increment()
 	}
-	bar.Finish()
+	sort.Strings(names)
+	return names
+}
+
+// ClusterPath returns the file of the cluster which is cut by the operation.
+// Clusters which add rows are in the directory of their category.
+// Silently removed clusters have no file, so an empty string is returned.
+func (p *Project) ClusterPath(category, keyword string, operation int) string {
+	ext := ".csv"
+	if p.Config.ClusterFormat == ClusterFormatXLSX {
+		ext = ".xlsx"
+	}
+	name := clusterFileName(keyword) + ext
+	switch operation {
+	case OperationRemove:
+		return filepath.Join(p.Paths.RemovedDir, name)
+	case OperationAdd:
+		return filepath.Join(p.CategoryDir(category), name)
+	}
+	return ""
+}
 
-	return convertMapToRows(rowMap)
+// clusterFileName replaces characters of the query syntax which are not allowed in file names
+func clusterFileName(keyword string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, keyword)
 }
 
-func resolveProjectPaths(path string) *ProjectPaths {
+func resolveProjectPaths(path string) (*ProjectPaths, error) {
 	absPath, err := filepath.Abs(path)
-	check(err)
+	if err != nil {
+		return nil, err
+	}
 	project := ProjectPaths{}
 	project.Dir = absPath
 	project.OriginalFile = filepath.Join(project.Dir, ProjectOriginalFile)
 	project.ClustersDir = filepath.Join(project.Dir, ProjectClustersDir)
 	project.RemainsFile = filepath.Join(project.Dir, ProjectRemainFile)
 	project.HistoryFile = filepath.Join(project.Dir, ProjectHistoryFile)
+	project.LegacyHistoryFile = filepath.Join(project.Dir, ProjectLegacyHistoryFile)
+	project.ConfigFile = filepath.Join(project.Dir, ProjectConfigFile)
+	project.CategoriesFile = filepath.Join(project.Dir, ProjectCategoriesFile)
 	project.RemovedDir = filepath.Join(project.ClustersDir, ProjectRemovedDir)
-	return &project
-}
-
-func SaveRows(rows []*Row, path string) {
-	mutex := &sync.Mutex{}
-	mutex.Lock()
-	dir := filepath.Dir(path)
-	os.MkdirAll(dir, 0777)
-	data, err := csvutil.Marshal(rows)
-	check(err)
-	err = ioutil.WriteFile(path, data, 0777)
-	mutex.Unlock()
-	check(err)
+	return &project, nil
 }
 
-func LoadRows(file string) []*Row {
-	data, err := ioutil.ReadFile(file)
-	if err != nil {
-		fmt.Printf("Can't read %s\n", file)
-		panic(err)
+// SaveRows writes the rows into the csv or xlsx file atomically
+func SaveRows(rows []*Row, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
 	}
-	var rows []Row
-	if err := csvutil.Unmarshal(data, &rows); err != nil {
-		panic("Can't parse csv file")
+	if isXLSXFile(path) {
+		return saveRowsXLSX(rows, path)
 	}
-
-	pointers := make([]*Row, len(rows))
-	for i := 0; i < len(rows); i++ {
-		pointers[i] = &rows[i]
+	data, err := csvutil.Marshal(rows)
+	if err != nil {
+		return err
 	}
-
-	return pointers
+	return writeFileDataAtomic(path, data)
 }
 
 // Utils
@@ -231,31 +686,3 @@ func convertMapToRows(m map[*Row]struct{}) []*Row {
 	}
 	return rows
 }
-
-func check(err error) {
-	if err != nil {
-		panic(err)
-	}
-}
-
-// Copy the src file to dst. Any existing file will be overwritten and will not
-// copy file attributes.
-func copyFile(src, dst string) error {
-	in, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer in.Close()
-
-	out, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	_, err = io.Copy(out, in)
-	if err != nil {
-		return err
-	}
-	return out.Close()
-}
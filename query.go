@@ -0,0 +1,492 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Query language of the search input and history operations:
+//
+//	грыжа лечение        both words (AND)
+//	грыжа | боль         any of the words, "OR" works too
+//	-бесплатно           without the word
+//	"лечение грыжи"      the exact phrase
+//	[грыжа позвоночник]  the words in this order
+//	лечен*               any word with the prefix
+//	freq>100             broad frequency, also exact (strong frequency) and words (word count)
+//	(a | b) -c           grouping
+//
+// Words are matched against both lemmas and keywords. If a normalizer is passed
+// words are also normalized, so any word form matches.
+
+// Query matches rows
+type Query interface {
+	Match(row *Row) bool
+}
+
+// QueryError is a syntax error with the position (in runes) of the wrong token
+type QueryError struct {
+	Position int
+	Message  string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("%s (позиция %d)", e.Message, e.Position+1)
+}
+
+var predicateRegexp = regexp.MustCompile(`^(freq|exact|words)(>=|<=|!=|>|<|=)(\d+)$`)
+
+// isPlainQuery checks whether the query is a list of words without any syntax,
+// such queries are matched by the fast path of the index
+func isPlainQuery(query string) bool {
+	for _, word := range strings.Fields(query) {
+		if word == "OR" || strings.ContainsAny(word, "|-\"[]()*<>=!") {
+			return false
+		}
+	}
+	return true
+}
+
+// queryGroup wraps the query into brackets so it can be combined with other words
+func queryGroup(query string) string {
+	if isPlainQuery(query) || !strings.ContainsAny(query, "|") && !strings.Contains(query, " OR ") {
+		return query
+	}
+	return "(" + query + ")"
+}
+
+// ParseQuery compiles the query. Parameter normalizer can be nil.
+func ParseQuery(text string, normalizer Normalizer) (Query, error) {
+	return parseQuerySynonyms(text, normalizer, nil)
+}
+
+// plainQueryForms returns the word forms which every word of the plain query matches:
+// the word, its lemma and its synonym group, as the parsed query does.
+func plainQueryForms(query string, normalizer Normalizer, synonyms Synonyms) [][]string {
+	p := &queryParser{normalizer: normalizer, synonyms: synonyms}
+	var forms [][]string
+	for _, word := range strings.Fields(query) {
+		term := p.newTerm(word)
+		words := []string{term.word}
+		if term.lemma != "" && term.lemma != term.word {
+			words = append(words, term.lemma)
+		}
+		forms = append(forms, append(words, term.synonyms...))
+	}
+	return forms
+}
+
+// parseQuerySynonyms compiles the query where every word also matches the words of its synonym group.
+// Words with a prefix are not expanded.
+func parseQuerySynonyms(text string, normalizer Normalizer, synonyms Synonyms) (Query, error) {
+	q, _, err := parseQueryGroups(text, normalizer, synonyms)
+	return q, err
+}
+
+// querySynonymGroups returns the synonym groups which the words of the query match, empty if there are none
+func querySynonymGroups(text string, normalizer Normalizer, synonyms Synonyms) ([][]string, error) {
+	_, groups, err := parseQueryGroups(text, normalizer, synonyms)
+	return groups, err
+}
+
+// parseQueryGroups is parseQuerySynonyms which also returns the matched synonym groups
+func parseQueryGroups(text string, normalizer Normalizer, synonyms Synonyms) (Query, [][]string, error) {
+	tokens, err := lexQuery(text)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &queryParser{tokens: tokens, normalizer: normalizer, synonyms: synonyms, end: len([]rune(text)),
+		groups: [][]string{}}
+	if len(tokens) == 0 {
+		return queryAll{}, p.groups, nil
+	}
+	q, err := p.parseOr()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !p.done() {
+		t := p.peek()
+		return nil, nil, &QueryError{t.pos, fmt.Sprintf("неожиданный символ \"%s\"", t.text)}
+	}
+	return q, p.groups, nil
+}
+
+// Selects the rows which match the query
+func queryRows(query Query, rows []*Row) []*Row {
+	var matched []*Row
+	for _, row := range rows {
+		if query.Match(row) {
+			matched = append(matched, row)
+		}
+	}
+	return matched
+}
+
+// selectRows filters rows by the query of any syntax.
+// Parameters normalizer and synonyms can be nil.
+func selectRows(query string, rows []*Row, normalizer Normalizer, synonyms Synonyms) ([]*Row, error) {
+	q, err := parseQuerySynonyms(query, normalizer, synonyms)
+	if err != nil {
+		return nil, err
+	}
+	return queryRows(q, rows), nil
+}
+
+// Lexer
+
+const (
+	tokenWord = iota
+	tokenPhrase
+	tokenOrdered
+	tokenPredicate
+	tokenOr
+	tokenNot
+	tokenOpen
+	tokenClose
+)
+
+type queryToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+func lexQuery(text string) ([]queryToken, error) {
+	var tokens []queryToken
+	r := []rune(text)
+	for i := 0; i < len(r); {
+		c := r[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, queryToken{tokenOpen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, queryToken{tokenClose, ")", i})
+			i++
+		case c == '|':
+			tokens = append(tokens, queryToken{tokenOr, "|", i})
+			i++
+		case c == '-':
+			if i+1 == len(r) || unicode.IsSpace(r[i+1]) {
+				return nil, &QueryError{i, "после минуса ожидается слово"}
+			}
+			tokens = append(tokens, queryToken{tokenNot, "-", i})
+			i++
+		case c == '"' || c == '[':
+			closing, kind := '"', tokenPhrase
+			if c == '[' {
+				closing, kind = ']', tokenOrdered
+			}
+			end := i + 1
+			for end < len(r) && r[end] != closing {
+				end++
+			}
+			if end >= len(r) {
+				return nil, &QueryError{i, fmt.Sprintf("не закрыт символ %c", c)}
+			}
+			tokens = append(tokens, queryToken{kind, string(r[i+1 : end]), i})
+			i = end + 1
+		default:
+			start := i
+			for i < len(r) && !unicode.IsSpace(r[i]) && !strings.ContainsRune("()|\"[]", r[i]) {
+				i++
+			}
+			if start == i {
+				return nil, &QueryError{i, fmt.Sprintf("неожиданный символ \"%c\"", c)}
+			}
+			word := string(r[start:i])
+			switch {
+			case word == "OR":
+				tokens = append(tokens, queryToken{tokenOr, word, start})
+			case predicateRegexp.MatchString(word):
+				tokens = append(tokens, queryToken{tokenPredicate, word, start})
+			case strings.ContainsAny(word, "<>=!"):
+				return nil, &QueryError{start, fmt.Sprintf("неверное условие \"%s\", пример: freq>100, exact<=10, words=3", word)}
+			default:
+				tokens = append(tokens, queryToken{tokenWord, word, start})
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// Parser
+
+type queryParser struct {
+	tokens     []queryToken
+	index      int
+	end        int
+	normalizer Normalizer
+	synonyms   Synonyms
+	// Synonym groups of the parsed words, every group once
+	groups [][]string
+}
+
+func (p *queryParser) done() bool {
+	return p.index >= len(p.tokens)
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.index]
+}
+
+func (p *queryParser) parseOr() (Query, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	alternatives := queryAny{left}
+	for !p.done() && p.peek().kind == tokenOr {
+		p.index++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		alternatives = append(alternatives, right)
+	}
+	if len(alternatives) == 1 {
+		return left, nil
+	}
+	return alternatives, nil
+}
+
+func (p *queryParser) parseAnd() (Query, error) {
+	var all queryEvery
+	for !p.done() && p.peek().kind != tokenOr && p.peek().kind != tokenClose {
+		q, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, q)
+	}
+	if len(all) == 0 {
+		pos := p.end
+		if !p.done() {
+			pos = p.peek().pos
+		}
+		return nil, &QueryError{pos, "ожидается слово"}
+	}
+	if len(all) == 1 {
+		return all[0], nil
+	}
+	return all, nil
+}
+
+func (p *queryParser) parseUnary() (Query, error) {
+	t := p.peek()
+	if t.kind == tokenNot {
+		p.index++
+		if p.done() {
+			return nil, &QueryError{t.pos, "после минуса ожидается слово"}
+		}
+		q, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return queryNot{q}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (Query, error) {
+	t := p.peek()
+	p.index++
+	switch t.kind {
+	case tokenOpen:
+		q, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.done() || p.peek().kind != tokenClose {
+			return nil, &QueryError{t.pos, "не закрыта скобка"}
+		}
+		p.index++
+		return q, nil
+	case tokenPhrase, tokenOrdered:
+		var terms []queryTerm
+		for _, word := range strings.Fields(t.text) {
+			terms = append(terms, p.newTerm(word))
+		}
+		if len(terms) == 0 {
+			return nil, &QueryError{t.pos, "пустая фраза"}
+		}
+		return queryPhrase{terms: terms, adjacent: t.kind == tokenPhrase}, nil
+	case tokenPredicate:
+		match := predicateRegexp.FindStringSubmatch(t.text)
+		value, err := strconv.ParseUint(match[3], 10, 32)
+		if err != nil {
+			return nil, &QueryError{t.pos, "слишком большое число"}
+		}
+		return queryPredicate{field: match[1], operator: match[2], value: uint32(value)}, nil
+	case tokenWord:
+		return p.newTerm(t.text), nil
+	}
+	return nil, &QueryError{t.pos, fmt.Sprintf("неожиданный символ \"%s\"", t.text)}
+}
+
+func (p *queryParser) newTerm(word string) queryTerm {
+	word = strings.ToLower(strings.Replace(word, "ё", "е", -1))
+	term := queryTerm{word: word}
+	if strings.HasSuffix(word, "*") {
+		term.word = strings.TrimRight(word, "*")
+		term.prefix = true
+	} else if p.normalizer != nil {
+		term.lemma = p.normalizer.Normalize(word)
+	}
+	if !term.prefix {
+		if term.synonyms = p.synonyms.Group(term.word); term.synonyms == nil {
+			term.synonyms = p.synonyms.Group(term.lemma)
+		}
+		if term.synonyms != nil && !containsGroup(p.groups, term.synonyms) {
+			p.groups = append(p.groups, term.synonyms)
+		}
+	}
+	return term
+}
+
+// Query nodes
+
+type queryAll struct{}
+
+func (queryAll) Match(row *Row) bool {
+	return true
+}
+
+type queryEvery []Query
+
+func (q queryEvery) Match(row *Row) bool {
+	for _, sub := range q {
+		if !sub.Match(row) {
+			return false
+		}
+	}
+	return true
+}
+
+type queryAny []Query
+
+func (q queryAny) Match(row *Row) bool {
+	for _, sub := range q {
+		if sub.Match(row) {
+			return true
+		}
+	}
+	return false
+}
+
+type queryNot struct {
+	Query
+}
+
+func (q queryNot) Match(row *Row) bool {
+	return !q.Query.Match(row)
+}
+
+// queryTerm is a single word
+type queryTerm struct {
+	word   string
+	lemma  string
+	prefix bool
+	// Words of the synonym group of the word
+	synonyms []string
+}
+
+func (t queryTerm) matchWord(word string) bool {
+	if t.prefix {
+		return strings.HasPrefix(word, t.word)
+	}
+	return word == t.word || (t.lemma != "" && word == t.lemma) || containsString(t.synonyms, word)
+}
+
+func (t queryTerm) Match(row *Row) bool {
+	for _, words := range rowWordForms(row) {
+		for _, word := range words {
+			if t.matchWord(word) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// queryPhrase is a sequence of words, adjacent or just in the order
+type queryPhrase struct {
+	terms    []queryTerm
+	adjacent bool
+}
+
+func (q queryPhrase) Match(row *Row) bool {
+	for _, words := range rowWordForms(row) {
+		if q.adjacent && matchAdjacent(q.terms, words) || !q.adjacent && matchOrdered(q.terms, words) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAdjacent(terms []queryTerm, words []string) bool {
+	for start := 0; start+len(terms) <= len(words); start++ {
+		matched := true
+		for i, term := range terms {
+			if !term.matchWord(words[start+i]) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func matchOrdered(terms []queryTerm, words []string) bool {
+	i := 0
+	for _, word := range words {
+		if i < len(terms) && terms[i].matchWord(word) {
+			i++
+		}
+	}
+	return i == len(terms)
+}
+
+type queryPredicate struct {
+	field    string
+	operator string
+	value    uint32
+}
+
+func (q queryPredicate) Match(row *Row) bool {
+	var v uint32
+	switch q.field {
+	case "freq":
+		v = row.Frequency
+	case "exact":
+		v = row.StrongFrequency
+	case "words":
+		v = uint32(len(strings.Fields(row.Keyword)))
+	}
+	switch q.operator {
+	case ">":
+		return v > q.value
+	case ">=":
+		return v >= q.value
+	case "<":
+		return v < q.value
+	case "<=":
+		return v <= q.value
+	case "!=":
+		return v != q.value
+	}
+	return v == q.value
+}
+
+// rowWordForms returns lemmas and keyword words of the row
+func rowWordForms(row *Row) [2][]string {
+	return [2][]string{strings.Fields(row.NormalizedKeyword), splitWords(row.Keyword)}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// queryRowsOf returns rows with the keywords lemmatized by the stemmer
+func queryRowsOf(keywords ...string) []*Row {
+	rows := make([]*Row, len(keywords))
+	for i, keyword := range keywords {
+		rows[i] = &Row{Keyword: keyword}
+	}
+	normalizeRows(rows, StemNormalizer{})
+	return rows
+}
+
+func TestPlainQueryMatchesParsedQuery(t *testing.T) {
+	rows := queryRowsOf(
+		"лечение грыжи позвоночника",
+		"грыжа позвоночника бесплатно",
+		"боль в спине",
+		"болит спина",
+		"Купить матрас",
+		"заказать матрас недорого",
+	)
+	synonyms := synonymsOfGroups([][]string{{"куп", "купить", "заказа", "заказать"}})
+	tests := []struct {
+		query    string
+		synonyms Synonyms
+		want     int
+	}{
+		{"грыжа", nil, 2},
+		{"грыж", nil, 2},
+		{"грыжи позвоночник", nil, 2},
+		{"Боль спины", nil, 2},
+		{"купить", nil, 1},
+		{"купить", synonyms, 2},
+		{"заказать матрас", synonyms, 2},
+		{"лечение боль", nil, 0},
+		{"пусто", nil, 0},
+	}
+	normalizer := StemNormalizer{}
+	for _, test := range tests {
+		index := NewWordIndex(rows)
+		plain, err := selectIndexRows(index, test.query, normalizer, test.synonyms)
+		if err != nil {
+			t.Fatalf("%s: %v", test.query, err)
+		}
+		// Brackets make the same query go through the parser
+		parsed, err := selectIndexRows(index, "("+test.query+")", normalizer, test.synonyms)
+		if err != nil {
+			t.Fatalf("(%s): %v", test.query, err)
+		}
+		if len(plain) != test.want || len(parsed) != test.want {
+			t.Errorf("%s: plain query selects %d rows, parsed query %d, want %d", test.query, len(plain), len(parsed), test.want)
+			continue
+		}
+		for i := range plain {
+			if plain[i] != parsed[i] {
+				t.Errorf("%s: row %d is %q, parsed query selects %q", test.query, i, plain[i].Keyword, parsed[i].Keyword)
+			}
+		}
+
+		index.SetSynonyms(test.synonyms)
+		live, err := selectRowsFrom(index, test.query, rows, normalizer, test.synonyms != nil, make(chan struct{}))
+		if err != nil || len(live) != test.want {
+			t.Errorf("%s: live search selects %d rows, want %d, error %v", test.query, len(live), test.want, err)
+		}
+		replayed, err := selectRows(test.query, rows, normalizer, test.synonyms)
+		if err != nil || len(replayed) != test.want {
+			t.Errorf("%s: replay selects %d rows, want %d, error %v", test.query, len(replayed), test.want, err)
+		}
+	}
+}
+
+func TestLexQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		kinds []int
+		texts []string
+	}{
+		{"", nil, nil},
+		{"грыжа лечение", []int{tokenWord, tokenWord}, []string{"грыжа", "лечение"}},
+		{"a|b OR c", []int{tokenWord, tokenOr, tokenWord, tokenOr, tokenWord}, []string{"a", "|", "b", "OR", "c"}},
+		{"(a) -b", []int{tokenOpen, tokenWord, tokenClose, tokenNot, tokenWord}, []string{"(", "a", ")", "-", "b"}},
+		{`"лечение грыжи" [грыжа позвоночник]`, []int{tokenPhrase, tokenOrdered}, []string{"лечение грыжи", "грыжа позвоночник"}},
+		{"лечен* freq>=100 words=3", []int{tokenWord, tokenPredicate, tokenPredicate}, []string{"лечен*", "freq>=100", "words=3"}},
+		{"wi-fi", []int{tokenWord}, []string{"wi-fi"}},
+	}
+	for _, test := range tests {
+		tokens, err := lexQuery(test.query)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		var kinds []int
+		var texts []string
+		for _, token := range tokens {
+			kinds = append(kinds, token.kind)
+			texts = append(texts, token.text)
+		}
+		if !reflect.DeepEqual(kinds, test.kinds) || !reflect.DeepEqual(texts, test.texts) {
+			t.Errorf("%s: tokens %v %q, want %v %q", test.query, kinds, texts, test.kinds, test.texts)
+		}
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	rows := queryRowsOf(
+		"лечение грыжи позвоночника",
+		"грыжа позвоночника бесплатно",
+		"боль в спине",
+		"позвоночник грыжа",
+	)
+	rows[0].Frequency, rows[0].StrongFrequency = 200, 20
+	rows[1].Frequency, rows[1].StrongFrequency = 50, 5
+	rows[2].Frequency = 10
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "0 1 2 3"},
+		{"грыжи", "0 1 3"},
+		{"грыжа | боль", "0 1 2 3"},
+		{"грыжа OR боль", "0 1 2 3"},
+		{"грыжа -бесплатно", "0 3"},
+		{"-(грыжа | боль)", ""},
+		{`"лечение грыжи"`, "0"},
+		{`"грыжи лечение"`, ""},
+		{"[грыжа позвоночник]", "0 1"},
+		{"[позвоночник грыжа]", "3"},
+		{"лечен*", "0"},
+		{"позвон*", "0 1 3"},
+		{"freq>=50", "0 1"},
+		{"freq<50", "2 3"},
+		{"exact=5", "1"},
+		{"exact!=0", "0 1"},
+		{"words=2", "3"},
+		{"(грыжа | боль) words=3 -бесплатно", "0 2"},
+		{"грыжа (лечение | бесплатно)", "0 1"},
+	}
+	for _, test := range tests {
+		q, err := ParseQuery(test.query, StemNormalizer{})
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		var matched []string
+		for i, row := range rows {
+			if q.Match(row) {
+				matched = append(matched, strconv.Itoa(i))
+			}
+		}
+		if got := strings.Join(matched, " "); got != test.want {
+			t.Errorf("%s: matched rows %q, want %q", test.query, got, test.want)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		query    string
+		position int
+	}{
+		{"(грыжа", 0},
+		{"грыжа)", 5},
+		{`"грыжа`, 0},
+		{"[грыжа", 0},
+		{"грыжа |", 7},
+		{"| грыжа", 0},
+		{"грыжа -", 6},
+		{"()", 1},
+		{`""`, 0},
+		{"freq>", 0},
+		{"цена>100", 0},
+		{"freq>99999999999", 0},
+	}
+	for _, test := range tests {
+		_, err := ParseQuery(test.query, nil)
+		queryErr, ok := err.(*QueryError)
+		if !ok {
+			t.Errorf("%s: error %v, want a query error", test.query, err)
+			continue
+		}
+		if queryErr.Position != test.position {
+			t.Errorf("%s: error at %d, want %d: %v", test.query, queryErr.Position, test.position, err)
+		}
+	}
+}
+
+func TestIsPlainQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		plain bool
+		group string
+	}{
+		{"грыжа лечение", true, "грыжа лечение"},
+		{"грыжа | боль", false, "(грыжа | боль)"},
+		{"грыжа OR боль", false, "(грыжа OR боль)"},
+		{"грыжа -боль", false, "грыжа -боль"},
+		{`"лечение грыжи"`, false, `"лечение грыжи"`},
+		{"лечен*", false, "лечен*"},
+		{"freq>10", false, "freq>10"},
+	}
+	for _, test := range tests {
+		if plain := isPlainQuery(test.query); plain != test.plain {
+			t.Errorf("%s: plain %v, want %v", test.query, plain, test.plain)
+		}
+		if group := queryGroup(test.query); group != test.group {
+			t.Errorf("%s: group %s, want %s", test.query, group, test.group)
+		}
+	}
+}
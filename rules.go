@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// LoadRules reads the operations of a rules file. Rules have the syntax of the text history,
+// one operation per line, and can have comments:
+//
+//	# brands
+//	-- adidas | nike
+//	- бесплатно
+//	+ купить
+//	+ грыжа лечение => Лечение грыжи
+//
+// The name after "=>" is the cluster the keywords are cut into, several rules can share it.
+// Lines of the current state ("= ...") are skipped, so an old history.txt can be used as rules.
+// Branch lines ("@ ...") are not allowed.
+func LoadRules(path string) ([]*KeywordOperation, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rules []*KeywordOperation
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		raw := scanner.Text()
+		text := strings.TrimSpace(raw)
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, "=") {
+			continue
+		}
+		// Column of the first character of the text in the raw line
+		column := utf8.RuneCountInString(raw[:strings.Index(raw, text)]) + 1
+		if strings.HasPrefix(text, "@") {
+			return nil, &FileError{File: path, Line: line, Column: column, Err: fmt.Errorf("history branches are not allowed in rules")}
+		}
+		var cluster string
+		if i := strings.Index(text, "=>"); i >= 0 {
+			cluster = strings.TrimSpace(text[i+len("=>"):])
+			if cluster == "" {
+				return nil, &FileError{File: path, Line: line, Column: column + utf8.RuneCountInString(text[:i]), Err: fmt.Errorf("cluster name is expected after =>")}
+			}
+			text = strings.TrimSpace(text[:i])
+		}
+		op, offset, err := parseTextOperation(text)
+		if err != nil {
+			return nil, &FileError{File: path, Line: line, Column: column + offset, Err: err}
+		}
+		op.Cluster = cluster
+		rules = append(rules, op)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &FileError{File: path, Err: err}
+	}
+	return rules, nil
+}
+
+// RuleResult is a rule applied by ApplyRules
+type RuleResult struct {
+	Rule *KeywordOperation
+	// Operation added into the history, nil if the rule matched no keywords
+	Operation *KeywordOperation
+}
+
+// ApplyRules cuts the keywords of every rule as the cluster tree does and saves the project.
+// Rules which match no keywords are not added into the history. Queries of all rules are checked
+// before anything is cut. If a rule fails anyway, the rules before it stay applied and saved,
+// so the history knows their cluster files.
+func (p *Project) ApplyRules(rules []*KeywordOperation) ([]RuleResult, error) {
+	for _, rule := range rules {
+		if err := validateQuery(rule.Keyword); err != nil {
+			return nil, fmt.Errorf("rule \"%s\": %v", rule.Keyword, err)
+		}
+	}
+
+	results := make([]RuleResult, 0, len(rules))
+	defer p.Save()
+	for _, rule := range rules {
+		rows, err := p.Select(rule.Keyword)
+		if err != nil {
+			return results, fmt.Errorf("rule \"%s\": %v", rule.Keyword, err)
+		}
+		result := RuleResult{Rule: rule}
+		if len(rows) > 0 {
+			if result.Operation, err = p.ProcessOperationInto(rule.Keyword, rule.Cluster, rows, rule.Operation); err != nil {
+				return results, fmt.Errorf("rule \"%s\": %v", rule.Keyword, err)
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// printRulesSummary writes the cut keywords of every rule and the totals
+func printRulesSummary(w io.Writer, results []RuleResult, remaining int) {
+	var applied, rows int
+	var frequency uint64
+	for _, result := range results {
+		prefix := operationPrefixes[result.Rule.Operation]
+		op := result.Operation
+		if op == nil {
+			fmt.Fprintf(w, "%-2s %s: no keywords\n", prefix, operationTarget(result.Rule))
+			continue
+		}
+		fmt.Fprintf(w, "%-2s %s: %d keywords, frequency %d\n", prefix, operationTarget(op), op.RowCount, op.Frequency)
+		applied++
+		rows += op.RowCount
+		frequency += op.Frequency
+	}
+	fmt.Fprintf(w, "Applied rules: %d of %d\n", applied, len(results))
+	fmt.Fprintf(w, "Cut keywords: %d, frequency %d\n", rows, frequency)
+	fmt.Fprintf(w, "Remaining keywords: %d\n", remaining)
+}
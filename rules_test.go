@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadRulesText writes the rules into a temporary file and loads them
+func loadRulesText(t *testing.T, text string) ([]*KeywordOperation, error) {
+	file, err := ioutil.TempFile("", "rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	return LoadRules(file.Name())
+}
+
+func TestLoadRules(t *testing.T) {
+	tests := []struct {
+		text  string
+		rules []string // prefixes, queries and clusters of the rules
+	}{
+		{"", nil},
+		{"# brands\n\n-- adidas | nike\n", []string{"-- adidas | nike"}},
+		{"+ грыжа\n- бесплатно\n", []string{"+ грыжа", "- бесплатно"}},
+		{"+ грыжа лечение => Лечение грыжи\n+ грыжа операция =>Лечение грыжи\n",
+			[]string{"+ грыжа лечение → Лечение грыжи", "+ грыжа операция → Лечение грыжи"}},
+		{"= грыжа\n+ грыжа\n=\n", []string{"+ грыжа"}},
+		{"  + \"лечение грыжи\"  \n", []string{"+ \"лечение грыжи\""}},
+	}
+	for _, test := range tests {
+		rules, err := loadRulesText(t, test.text)
+		if err != nil {
+			t.Errorf("%q: %v", test.text, err)
+			continue
+		}
+		var got []string
+		for _, rule := range rules {
+			got = append(got, operationPrefixes[rule.Operation]+" "+operationTarget(rule))
+		}
+		if strings.Join(got, "\n") != strings.Join(test.rules, "\n") {
+			t.Errorf("%q: rules %q, want %q", test.text, got, test.rules)
+		}
+	}
+}
+
+func TestLoadRulesErrors(t *testing.T) {
+	tests := []struct {
+		text   string
+		line   int
+		column int
+	}{
+		{"+ грыжа\n@ ветка\n", 2, 1},
+		{"+ грыжа\n  @ ветка\n", 2, 3},
+		{"+ грыжа =>\n", 1, 9},
+		{"+ грыжа =>   \n", 1, 9},
+		{"* грыжа\n", 1, 1},
+		{"# comment\n+ (грыжа\n", 2, 3},
+		{"+ грыжа |\n", 1, 10},
+	}
+	for _, test := range tests {
+		_, err := loadRulesText(t, test.text)
+		fileErr, ok := err.(*FileError)
+		if !ok {
+			t.Errorf("%q: error %v, want a file error", test.text, err)
+			continue
+		}
+		if fileErr.Line != test.line || fileErr.Column != test.column {
+			t.Errorf("%q: error at %d:%d, want %d:%d: %v", test.text, fileErr.Line, fileErr.Column, test.line, test.column, err)
+		}
+	}
+}
+
+func TestApplyRules(t *testing.T) {
+	p := newTestProject(t, "buy shoes", "cheap shoes", "red boots", "free hat", "blue hat")
+	defer closeTestProject(t, p)
+	rules, err := loadRulesText(t, "-- free\n+ shoes => Обувь\n+ boots => Обувь\n+ socks\n+ hat\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := p.ApplyRules(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, result := range results {
+		if result.Operation == nil {
+			got = append(got, operationTarget(result.Rule)+": 0")
+			continue
+		}
+		got = append(got, fmt.Sprintf("%s: %d %s", operationTarget(result.Operation), result.Operation.RowCount, result.Operation.File))
+	}
+	want := []string{
+		"free: 1 ",
+		"shoes → Обувь: 2 " + filepath.Join("clusters", "Обувь.csv"),
+		"boots → Обувь: 1 " + filepath.Join("clusters", "Обувь.csv"),
+		"socks: 0",
+		"hat: 1 " + filepath.Join("clusters", "hat.csv"),
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("results %q, want %q", got, want)
+	}
+	if len(p.History.Operations) != 4 || len(p.Rows) != 0 {
+		t.Errorf("%d operations and %d rows after the rules", len(p.History.Operations), len(p.Rows))
+	}
+
+	// Rules which share the cluster write one file with the rows of both
+	if err := p.flush(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(p.Paths.ClustersDir, "Обувь.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, keyword := range []string{"buy shoes", "cheap shoes", "red boots"} {
+		if !strings.Contains(string(data), keyword) {
+			t.Errorf("cluster file has no %q:\n%s", keyword, data)
+		}
+	}
+}
+
+func TestApplyRulesInvalidQuery(t *testing.T) {
+	p := newTestProject(t, "buy shoes", "red boots")
+	defer closeTestProject(t, p)
+	rules := []*KeywordOperation{
+		{Keyword: "shoes", Operation: OperationAdd},
+		{Keyword: "(boots", Operation: OperationAdd},
+	}
+	if _, err := p.ApplyRules(rules); err == nil {
+		t.Fatal("rules with an invalid query are applied")
+	}
+	if len(p.History.Operations) != 0 || remainingKeywords(p) != "buy shoes, red boots" {
+		t.Errorf("rows are cut before the queries are checked: %s", remainingKeywords(p))
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// SavedCluster is a cluster of the applied operations: the rows which are cut into one file.
+// Silently removed rows have no file, they are grouped by the query.
+type SavedCluster struct {
+	Name      string
+	Category  string
+	Operation int
+	// Path of the cluster inside the clusters directory, like "Категория/Кластер" or "removed/Кластер"
+	Label string
+	// Cluster file, empty for silently removed rows
+	Path       string
+	Operations []*KeywordOperation
+}
+
+// Rows returns the rows which are left in the cluster
+func (c *SavedCluster) Rows() []*Row {
+	var rows []*Row
+	for _, op := range c.Operations {
+		rows = append(rows, op.rows...)
+	}
+	return rows
+}
+
+// SavedClusters returns the clusters of the applied operations of the active branch in the history order.
+// Clusters whose rows are all restored or merged into other ones are skipped.
+func (p *Project) SavedClusters() []*SavedCluster {
+	var clusters []*SavedCluster
+	byLabel := make(map[string]*SavedCluster)
+	for _, op := range p.History.Operations[:p.History.CurrentStateIndex+1] {
+		label := op.clusterLabel()
+		if label == "" {
+			continue
+		}
+		cluster, ok := byLabel[label]
+		if !ok {
+			cluster = &SavedCluster{
+				Name:      op.ClusterName(),
+				Category:  op.Category,
+				Operation: op.Operation,
+				Label:     label,
+				Path:      p.ClusterPath(op.Category, op.ClusterName(), op.Operation),
+			}
+			byLabel[label] = cluster
+			clusters = append(clusters, cluster)
+		}
+		cluster.Operations = append(cluster.Operations, op)
+	}
+
+	saved := clusters[:0]
+	for _, cluster := range clusters {
+		for _, op := range cluster.Operations {
+			if len(op.rows) > 0 {
+				saved = append(saved, cluster)
+				break
+			}
+		}
+	}
+	return saved
+}
+
+// RestoreRows returns the rows of the cluster to the remaining rows and adds a restore operation
+// to the history. The operation keeps the keywords, so it restores the same rows when the history
+// is applied again. The cluster file is written without the rows.
+func (p *Project) RestoreRows(cluster *SavedCluster, rows []*Row) *KeywordOperation {
+	op := p.History.AddOperation(cluster.Label, OperationRestore)
+	op.Time = time.Now()
+	op.Keywords = make([]string, len(rows))
+	for i, row := range rows {
+		op.Keywords[i] = row.Keyword
+	}
+	op.takeRows(p.History.Operations[:p.History.CurrentStateIndex])
+	p.Index.Restore(op.rows)
+	p.Rows = p.Index.Rows()
+	p.SaveClusterFiles(withSourceOperations([]*KeywordOperation{op}))
+	return op
+}
+
+// MergeClusters moves the rows of the cluster into the target one and adds a merge operation
+// to the history. The operation keeps the keywords, so it moves the same rows when the history
+// is applied again, and it's undone as other operations. The file of the cluster is deleted.
+func (p *Project) MergeClusters(cluster, target *SavedCluster) (*KeywordOperation, error) {
+	if cluster.Path == "" || target.Path == "" {
+		return nil, fmt.Errorf("silently removed keywords have no cluster file")
+	}
+	if cluster.Path == target.Path {
+		return nil, fmt.Errorf("cluster can't be merged with itself")
+	}
+	if cluster.Operation != target.Operation {
+		return nil, fmt.Errorf("saved and removed clusters can't be merged")
+	}
+	rows := cluster.Rows()
+	op := p.History.AddOperation(cluster.Label, target.Operation)
+	op.Time = time.Now()
+	op.Merge = cluster.Label
+	op.Cluster = target.Name
+	op.Category = target.Category
+	op.File = p.relativePath(target.Path)
+	op.Keywords = make([]string, len(rows))
+	for i, row := range rows {
+		op.Keywords[i] = row.Keyword
+	}
+	op.takeRows(p.History.Operations[:p.History.CurrentStateIndex])
+	p.SaveClusterFiles(withSourceOperations([]*KeywordOperation{op}))
+	return op, nil
+}
+
+// moveClusterOperations moves the cluster of the operation into the cluster with the category and the name.
+// All operations of the branches which cut rows into the same file are moved with it.
+func (p *Project) moveClusterOperations(op *KeywordOperation, category, name string) {
+	path := p.ClusterPath(op.Category, op.ClusterName(), op.Operation)
+	label := op.clusterLabel()
+	var moved []*KeywordOperation
+	for _, branch := range p.History.Branches {
+		for _, other := range branch.Operations {
+			if other.Operation == op.Operation && !containsOperation(moved, other) &&
+				p.ClusterPath(other.Category, other.ClusterName(), other.Operation) == path {
+				moved = append(moved, other)
+			}
+		}
+	}
+	for _, other := range moved {
+		other.Category = category
+		if other.ClusterName() != name {
+			other.Cluster = name
+		}
+		other.File = p.relativePath(p.ClusterPath(other.Category, other.ClusterName(), other.Operation))
+	}
+	// Restore and merge operations find the cluster by its label
+	for _, branch := range p.History.Branches {
+		for _, other := range branch.Operations {
+			if other.sourceLabel() == label {
+				other.setSourceLabel(op.clusterLabel())
+			}
+		}
+	}
+	p.removeFile(path)
+	p.SaveClusterFiles(moved)
+	p.SaveHistory()
+}
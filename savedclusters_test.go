@@ -0,0 +1,165 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// savedClusterRows returns the labels of the saved clusters with the keywords of their rows
+func savedClusterRows(p *Project) string {
+	var clusters []string
+	for _, cluster := range p.SavedClusters() {
+		var keywords []string
+		for _, row := range cluster.Rows() {
+			keywords = append(keywords, row.Keyword)
+		}
+		clusters = append(clusters, cluster.Label+": "+strings.Join(keywords, ", "))
+	}
+	return strings.Join(clusters, "; ")
+}
+
+// findSavedCluster returns the saved cluster with the label, nil if there is none
+func findSavedCluster(p *Project, label string) *SavedCluster {
+	for _, cluster := range p.SavedClusters() {
+		if cluster.Label == label {
+			return cluster
+		}
+	}
+	return nil
+}
+
+// restoreKeywords restores the rows of the cluster with the keywords
+func restoreKeywords(t *testing.T, p *Project, label string, keywords ...string) {
+	cluster := findSavedCluster(p, label)
+	if cluster == nil {
+		t.Fatalf("no saved cluster %s", label)
+	}
+	var rows []*Row
+	for _, row := range cluster.Rows() {
+		if containsString(keywords, row.Keyword) {
+			rows = append(rows, row)
+		}
+	}
+	p.RestoreRows(cluster, rows)
+}
+
+func TestSavedClusters(t *testing.T) {
+	p := newTestProject(t, "buy shoes", "cheap shoes", "red boots", "blue hat", "cheap hat")
+	defer closeTestProject(t, p)
+	if clusters := savedClusterRows(p); clusters != "" {
+		t.Errorf("new project has saved clusters %s", clusters)
+	}
+	cutQuery(t, p, "shoes", OperationAdd)
+	cutQuery(t, p, "boots", OperationRemove)
+	cutQuery(t, p, "blue", OperationSilentRemove)
+	if _, err := p.ProcessOperationInto("hat", "shoes", []*Row{p.Rows[0]}, OperationAdd); err != nil {
+		t.Fatal(err)
+	}
+	want := "shoes: buy shoes, cheap shoes, cheap hat; removed/boots: red boots; -- blue: blue hat"
+	if clusters := savedClusterRows(p); clusters != want {
+		t.Errorf("saved clusters %s, want %s", clusters, want)
+	}
+	p.Undo()
+	p.Undo()
+	if clusters := savedClusterRows(p); clusters != "shoes: buy shoes, cheap shoes; removed/boots: red boots" {
+		t.Errorf("saved clusters %s after undo", clusters)
+	}
+}
+
+func TestRestoreAndMergeClusters(t *testing.T) {
+	p := newTestProject(t, "buy shoes", "cheap shoes", "red boots", "buy boots", "blue hat")
+	cutQuery(t, p, "shoes", OperationAdd)
+	cutQuery(t, p, "red", OperationAdd)
+	cutQuery(t, p, "hat", OperationRemove)
+
+	tests := []struct {
+		name      string
+		do        func()
+		remaining string
+		clusters  string
+	}{
+		{"restore", func() { restoreKeywords(t, p, "shoes", "cheap shoes") },
+			"cheap shoes, buy boots",
+			"shoes: buy shoes; red: red boots; removed/hat: blue hat"},
+		{"cut the restored rows", func() { cutQuery(t, p, "cheap", OperationAdd) },
+			"buy boots",
+			"shoes: buy shoes; red: red boots; removed/hat: blue hat; cheap: cheap shoes"},
+		// The keyword is only in the selected cluster
+		{"restore from another cluster", func() { restoreKeywords(t, p, "red", "red boots", "cheap shoes") },
+			"red boots, buy boots",
+			"shoes: buy shoes; removed/hat: blue hat; cheap: cheap shoes"},
+		{"merge", func() {
+			if _, err := p.MergeClusters(findSavedCluster(p, "cheap"), findSavedCluster(p, "shoes")); err != nil {
+				t.Fatal(err)
+			}
+		}, "red boots, buy boots", "shoes: buy shoes, cheap shoes; removed/hat: blue hat"},
+		{"undo the merge", func() { p.Undo() },
+			"red boots, buy boots",
+			"shoes: buy shoes; removed/hat: blue hat; cheap: cheap shoes"},
+		{"redo the merge", func() {
+			if _, err := p.Redo(); err != nil {
+				t.Fatal(err)
+			}
+		}, "red boots, buy boots", "shoes: buy shoes, cheap shoes; removed/hat: blue hat"},
+		{"restore from the merged cluster", func() { restoreKeywords(t, p, "shoes", "cheap shoes") },
+			"cheap shoes, red boots, buy boots",
+			"shoes: buy shoes; removed/hat: blue hat"},
+		{"restore a removed cluster", func() { restoreKeywords(t, p, "removed/hat", "blue hat") },
+			"cheap shoes, red boots, buy boots, blue hat",
+			"shoes: buy shoes"},
+		{"undo the restores", func() { p.Undo(); p.Undo() },
+			"red boots, buy boots",
+			"shoes: buy shoes, cheap shoes; removed/hat: blue hat"},
+	}
+	for _, test := range tests {
+		test.do()
+		if remaining := remainingKeywords(p); remaining != test.remaining {
+			t.Errorf("%s: remaining %q, want %q", test.name, remaining, test.remaining)
+		}
+		if clusters := savedClusterRows(p); clusters != test.clusters {
+			t.Errorf("%s: saved clusters %q, want %q", test.name, clusters, test.clusters)
+		}
+	}
+
+	// The history cuts, restores and merges the same rows when it's applied again
+	if _, err := p.Redo(); err != nil {
+		t.Fatal(err)
+	}
+	want := savedClusterRows(p)
+	p.Save()
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := LoadProject(p.Paths.Dir, SourceOptions{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clusters := savedClusterRows(p); clusters != want {
+		t.Errorf("saved clusters %q after the project is loaded, want %q", clusters, want)
+	}
+	closeTestProject(t, p)
+}
+
+func TestMergeClustersErrors(t *testing.T) {
+	p := newTestProject(t, "buy shoes", "red boots", "blue hat")
+	defer closeTestProject(t, p)
+	cutQuery(t, p, "shoes", OperationAdd)
+	cutQuery(t, p, "boots", OperationRemove)
+	cutQuery(t, p, "hat", OperationSilentRemove)
+	tests := []struct {
+		cluster, target string
+	}{
+		{"shoes", "shoes"},
+		{"shoes", "removed/boots"},
+		{"-- hat", "shoes"},
+		{"shoes", "-- hat"},
+	}
+	for _, test := range tests {
+		if _, err := p.MergeClusters(findSavedCluster(p, test.cluster), findSavedCluster(p, test.target)); err == nil {
+			t.Errorf("%s is merged into %s", test.cluster, test.target)
+		}
+	}
+	if len(p.History.Operations) != 3 {
+		t.Errorf("%d operations after failed merges", len(p.History.Operations))
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/jszwec/csvutil"
+	"io"
+)
+
+// Rows are allocated by chunks to avoid millions of small allocations
+const rowChunkSize = 4096
+
+// How many malformed lines are printed after loading
+const maxPrintedRowErrors = 20
+
+// RowError describes a source line which can't be loaded
+type RowError struct {
+	File   string
+	Line   int
+	Column string
+	Err    error
+}
+
+func (e *RowError) Error() string {
+	if e.Column != "" {
+		return fmt.Sprintf("%s:%d: column \"%s\": %v", e.File, e.Line, e.Column, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+// recordReader reads records of a csv or xlsx source
+type recordReader interface {
+	Read() ([]string, error)
+	// Line returns the line of the last read record
+	Line() int
+	Close() error
+}
+
+// csvRecordReader reads records of a decoded csv stream
+type csvRecordReader struct {
+	*csv.Reader
+	source *sourceStream
+}
+
+func (r *csvRecordReader) Line() int {
+	line, _ := r.FieldPos(0)
+	return line
+}
+
+func (r *csvRecordReader) Close() error {
+	return r.source.Close()
+}
+
+func openCSVRecords(file string, options SourceOptions, progress bool) (*csvRecordReader, error) {
+	source, err := openSource(file, options, progress)
+	if err != nil {
+		return nil, err
+	}
+	reader := csv.NewReader(source)
+	reader.Comma = source.Delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.ReuseRecord = true
+	return &csvRecordReader{Reader: reader, source: source}, nil
+}
+
+// LoadRows reads the csv or xlsx file row by row and shows the reading progress.
+// Malformed lines are skipped and printed after loading.
+// The options describe the file format and map its headers to the Row fields.
+func LoadRows(file string, options SourceOptions) ([]*Row, error) {
+	fmt.Printf("Loading %s...\n", file)
+	rows, rowErrors, err := readRows(file, options, true)
+	if err != nil {
+		return nil, fmt.Errorf("can't read %s: %v", file, err)
+	}
+	printRowErrors(rowErrors)
+	return rows, nil
+}
+
+// ReadRows quietly reads the file written by the project
+func ReadRows(file string) ([]*Row, error) {
+	rows, _, err := readRows(file, SourceOptions{}, false)
+	return rows, err
+}
+
+func readRows(file string, options SourceOptions, progress bool) ([]*Row, []*RowError, error) {
+	var reader recordReader
+	var err error
+	if isXLSXFile(file) {
+		reader, err = openXLSXRecords(file, options, progress)
+	} else {
+		reader, err = openCSVRecords(file, options, progress)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't read header: %v", err)
+	}
+	header, err = options.Columns.Detect(header).Resolve(header)
+	if err != nil {
+		return nil, nil, err
+	}
+	decoder, err := csvutil.NewDecoder(reader, header...)
+	if err != nil {
+		return nil, nil, err
+	}
+	decoder.Map = mapColumnValue
+
+	var rows []*Row
+	var rowErrors []*RowError
+	var chunk []Row
+	for {
+		if len(chunk) == cap(chunk) {
+			chunk = make([]Row, 0, rowChunkSize)
+		}
+		chunk = chunk[:len(chunk)+1]
+		row := &chunk[len(chunk)-1]
+
+		err := decoder.Decode(row)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			*row = Row{}
+			chunk = chunk[:len(chunk)-1]
+			rowErr := newRowError(file, reader, decoder, err)
+			if rowErr == nil {
+				return nil, nil, err
+			}
+			rowErrors = append(rowErrors, rowErr)
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, rowErrors, nil
+}
+
+// newRowError locates the malformed line. It returns nil if the error is not
+// related to the line content, so reading can't be continued.
+func newRowError(file string, reader recordReader, decoder *csvutil.Decoder, err error) *RowError {
+	rowErr := &RowError{File: file, Err: err}
+
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		rowErr.Line = parseErr.Line
+		rowErr.Err = parseErr.Err
+		return rowErr
+	}
+
+	if len(decoder.Record()) == 0 {
+		return nil
+	}
+	rowErr.Line = reader.Line()
+	if err == csvutil.ErrFieldCount {
+		rowErr.Err = fmt.Errorf("expected %d fields, got %d", len(decoder.Header()), len(decoder.Record()))
+	}
+
+	var typeErr *csvutil.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		header := decoder.Header()
+		for i, value := range decoder.Record() {
+			if value == typeErr.Value && i < len(header) && isCanonicalColumn(header[i]) {
+				rowErr.Column = header[i]
+				break
+			}
+		}
+		rowErr.Err = fmt.Errorf("\"%s\" is not a number", typeErr.Value)
+	}
+	return rowErr
+}
+
+func printRowErrors(rowErrors []*RowError) {
+	if len(rowErrors) == 0 {
+		return
+	}
+	fmt.Printf("%d malformed lines were skipped:\n", len(rowErrors))
+	for i, err := range rowErrors {
+		if i == maxPrintedRowErrors {
+			fmt.Printf("  ...and %d more\n", len(rowErrors)-maxPrintedRowErrors)
+			break
+		}
+		fmt.Printf("  %v\n", err)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+	"gopkg.in/cheggaaa/pb.v2"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// How many bytes are inspected to detect encoding and delimiter
+const sourceSniffSize = 64 * 1024
+
+// Delimiters which are detected automatically
+var sourceDelimiters = []rune{',', ';', '\t', '|'}
+
+// Supported encodings by their names in the -encoding flag
+var sourceEncodings = map[string]encoding.Encoding{
+	"utf-8":    unicode.UTF8,
+	"cp1251":   charmap.Windows1251,
+	"utf-16le": unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM),
+	"utf-16be": unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+}
+
+// SourceOptions describes how a source file is read
+type SourceOptions struct {
+	Columns ColumnMapping
+	// Name of the normalizer which fills missing lemmas
+	Normalizer string
+	// Field delimiter, detected if empty
+	Delimiter string
+	// One of sourceEncodings, detected if empty
+	Encoding string
+	// Name or number of the xlsx sheet, the first sheet is used if empty
+	Sheet string
+}
+
+// sourceStream is a decompressed and transcoded to UTF-8 source file
+type sourceStream struct {
+	io.Reader
+	Delimiter rune
+
+	bar     *pb.ProgressBar
+	closers []io.Closer
+}
+
+func (s *sourceStream) Close() error {
+	if s.bar != nil {
+		s.bar.Finish()
+	}
+	var err error
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		if e := s.closers[i].Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// openSource opens the file and transparently decompresses gzip and zip archives,
+// converts the content to UTF-8 and detects the delimiter.
+// The reading progress is shown until the stream is closed if progress is true.
+func openSource(file string, options SourceOptions, progress bool) (*sourceStream, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	stream := &sourceStream{closers: []io.Closer{f}}
+
+	info, err := f.Stat()
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+
+	magic := make([]byte, 4)
+	n, _ := io.ReadFull(f, magic)
+	magic = magic[:n]
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		stream.Close()
+		return nil, err
+	}
+
+	var reader io.Reader
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		// Progress is measured by compressed bytes
+		stream.bar = newSourceBar(info.Size(), progress)
+		gz, err := gzip.NewReader(stream.bar.NewProxyReader(f))
+		if err != nil {
+			stream.Close()
+			return nil, fmt.Errorf("can't decompress %s: %v", file, err)
+		}
+		stream.closers = append(stream.closers, gz)
+		reader = gz
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		entry, err := openZipEntry(f, info.Size())
+		if err != nil {
+			stream.Close()
+			return nil, fmt.Errorf("can't decompress %s: %v", file, err)
+		}
+		stream.bar = newSourceBar(int64(entry.UncompressedSize64), progress)
+		rc, err := entry.Open()
+		if err != nil {
+			stream.Close()
+			return nil, err
+		}
+		stream.closers = append(stream.closers, rc)
+		reader = stream.bar.NewProxyReader(rc)
+	default:
+		stream.bar = newSourceBar(info.Size(), progress)
+		reader = stream.bar.NewProxyReader(f)
+	}
+
+	buffered := bufio.NewReaderSize(reader, sourceSniffSize)
+	sample, _ := buffered.Peek(sourceSniffSize)
+
+	enc, bomLength, err := detectEncoding(sample, options.Encoding)
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+	if bomLength > 0 {
+		buffered.Discard(bomLength)
+	}
+	if enc == unicode.UTF8 {
+		stream.Reader = buffered
+	} else {
+		stream.Reader = bufio.NewReaderSize(transform.NewReader(buffered, enc.NewDecoder()), sourceSniffSize)
+	}
+
+	if options.Delimiter != "" {
+		stream.Delimiter, err = parseDelimiter(options.Delimiter)
+		if err != nil {
+			stream.Close()
+			return nil, err
+		}
+	} else {
+		decodedSample, _ := stream.Reader.(*bufio.Reader).Peek(sourceSniffSize)
+		stream.Delimiter = detectDelimiter(decodedSample)
+	}
+
+	return stream, nil
+}
+
+// newSourceBar returns the bar which is only drawn if progress is true
+func newSourceBar(size int64, progress bool) *pb.ProgressBar {
+	bar := pb.New64(size)
+	bar.Set(pb.Bytes, true)
+	if progress {
+		bar.Start()
+	}
+	return bar
+}
+
+// openZipEntry returns the first csv-like file of the archive
+func openZipEntry(f *os.File, size int64) (*zip.File, error) {
+	archive, err := zip.NewReader(f, size)
+	if err != nil {
+		return nil, err
+	}
+	var candidate *zip.File
+	for _, entry := range archive.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(entry.Name)) {
+		case ".csv", ".tsv", ".txt":
+			return entry, nil
+		}
+		if candidate == nil {
+			candidate = entry
+		}
+	}
+	if candidate == nil {
+		return nil, fmt.Errorf("archive is empty")
+	}
+	return candidate, nil
+}
+
+// detectEncoding returns the encoding of the sample and length of its BOM.
+// Parameter name forces the encoding, the BOM is still skipped.
+func detectEncoding(sample []byte, name string) (encoding.Encoding, int, error) {
+	bomLength := 0
+	var bomEncoding encoding.Encoding
+	switch {
+	case bytes.HasPrefix(sample, []byte{0xef, 0xbb, 0xbf}):
+		bomLength, bomEncoding = 3, unicode.UTF8
+	case bytes.HasPrefix(sample, []byte{0xff, 0xfe}):
+		bomLength, bomEncoding = 2, sourceEncodings["utf-16le"]
+	case bytes.HasPrefix(sample, []byte{0xfe, 0xff}):
+		bomLength, bomEncoding = 2, sourceEncodings["utf-16be"]
+	}
+
+	name = strings.ToLower(name)
+	if name != "" && name != "auto" {
+		enc, ok := sourceEncodings[name]
+		if !ok {
+			return nil, 0, fmt.Errorf("unknown encoding \"%s\", available: utf-8, cp1251, utf-16le, utf-16be", name)
+		}
+		if enc != bomEncoding {
+			bomLength = 0
+		}
+		return enc, bomLength, nil
+	}
+
+	if bomEncoding != nil {
+		return bomEncoding, bomLength, nil
+	}
+
+	// UTF-16 without BOM has zero bytes in every second position of ASCII text
+	var evenZeros, oddZeros int
+	for i, b := range sample {
+		if b == 0 {
+			if i%2 == 0 {
+				evenZeros++
+			} else {
+				oddZeros++
+			}
+		}
+	}
+	if oddZeros > len(sample)/4 {
+		return sourceEncodings["utf-16le"], 0, nil
+	}
+	if evenZeros > len(sample)/4 {
+		return sourceEncodings["utf-16be"], 0, nil
+	}
+
+	if utf8.Valid(trimIncompleteRune(sample)) {
+		return unicode.UTF8, 0, nil
+	}
+	// The most common single-byte encoding of Russian exports
+	return charmap.Windows1251, 0, nil
+}
+
+// trimIncompleteRune cuts a multi-byte rune which was split by the sample size
+func trimIncompleteRune(sample []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(sample); i++ {
+		if utf8.RuneStart(sample[len(sample)-i]) {
+			if !utf8.FullRune(sample[len(sample)-i:]) {
+				return sample[:len(sample)-i]
+			}
+			break
+		}
+	}
+	return sample
+}
+
+// detectDelimiter counts delimiters of the first line outside of quotes
+func detectDelimiter(sample []byte) rune {
+	counts := make(map[rune]int)
+	quoted := false
+	for _, r := range string(sample) {
+		if r == '"' {
+			quoted = !quoted
+		} else if !quoted && (r == '\n' || r == '\r') {
+			break
+		} else if !quoted {
+			counts[r]++
+		}
+	}
+
+	delimiter := sourceDelimiters[0]
+	for _, d := range sourceDelimiters {
+		if counts[d] > counts[delimiter] {
+			delimiter = d
+		}
+	}
+	return delimiter
+}
+
+func parseDelimiter(s string) (rune, error) {
+	switch strings.ToLower(s) {
+	case "tab", "\\t":
+		return '\t', nil
+	case "comma":
+		return ',', nil
+	case "semicolon":
+		return ';', nil
+	}
+	if utf8.RuneCountInString(s) != 1 {
+		return 0, fmt.Errorf("delimiter must be a single character, got \"%s\"", s)
+	}
+	r, _ := utf8.DecodeRuneInString(s)
+	return r, nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import "strings"
+
+// English stemmer. Implementation of the Snowball (Porter2) algorithm:
+// https://snowballstem.org/algorithms/english/stemmer.html
+
+var enExceptions = map[string]string{
+	"skies": "sky", "dying": "die", "lying": "lie", "tying": "tie",
+	"idly": "idl", "gently": "gentl", "ugly": "ugli", "early": "earli", "only": "onli", "singly": "singl",
+	"sky": "sky", "news": "news", "howe": "howe", "atlas": "atlas", "cosmos": "cosmos", "bias": "bias", "andes": "andes",
+}
+
+var enExceptionsAfterStep1a = map[string]struct{}{
+	"inning": {}, "outing": {}, "canning": {}, "herring": {}, "earring": {},
+	"proceed": {}, "exceed": {}, "succeed": {},
+}
+
+type enSuffix struct {
+	suffix  string
+	replace string
+}
+
+var enStep2Suffixes = []enSuffix{
+	{"ization", "ize"}, {"ational", "ate"}, {"fulness", "ful"}, {"ousness", "ous"}, {"iveness", "ive"},
+	{"tional", "tion"}, {"biliti", "ble"}, {"lessli", "less"},
+	{"entli", "ent"}, {"ation", "ate"}, {"alism", "al"}, {"aliti", "al"}, {"ousli", "ous"}, {"iviti", "ive"}, {"fulli", "ful"},
+	{"enci", "ence"}, {"anci", "ance"}, {"abli", "able"}, {"izer", "ize"}, {"ator", "ate"}, {"alli", "al"},
+	{"bli", "ble"}, {"ogi", "og"}, {"li", ""},
+}
+
+var enStep3Suffixes = []enSuffix{
+	{"ational", "ate"}, {"tional", "tion"}, {"alize", "al"}, {"icate", "ic"}, {"iciti", "ic"}, {"ative", ""},
+	{"ical", "ic"}, {"ness", ""}, {"ful", ""},
+}
+
+var enStep4Suffixes = []string{
+	"ement", "ance", "ence", "able", "ible", "ment", "ant", "ent", "ism", "ate", "iti", "ous", "ive", "ize", "ion",
+	"al", "er", "ic",
+}
+
+func stemEnglish(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	if stem, ok := enExceptions[word]; ok {
+		return stem
+	}
+
+	w := []byte(strings.TrimPrefix(word, "'"))
+	if len(w) == 0 {
+		return word
+	}
+
+	// Mark consonant y as Y
+	for i := range w {
+		if w[i] == 'y' && (i == 0 || isEnglishVowel(w[i-1])) {
+			w[i] = 'Y'
+		}
+	}
+
+	r1, r2 := englishRegions(w)
+
+	// Step 0
+	for _, suffix := range []string{"'s'", "'s", "'"} {
+		if hasByteSuffix(w, suffix) {
+			w = w[:len(w)-len(suffix)]
+			break
+		}
+	}
+
+	// Step 1a
+	switch {
+	case hasByteSuffix(w, "sses"):
+		w = w[:len(w)-2]
+	case hasByteSuffix(w, "ied"), hasByteSuffix(w, "ies"):
+		if len(w) > 4 {
+			w = w[:len(w)-2]
+		} else {
+			w = w[:len(w)-1]
+		}
+	case hasByteSuffix(w, "us"), hasByteSuffix(w, "ss"):
+	case hasByteSuffix(w, "s"):
+		if containsEnglishVowel(w[:len(w)-2]) {
+			w = w[:len(w)-1]
+		}
+	}
+
+	if _, ok := enExceptionsAfterStep1a[string(w)]; ok {
+		return string(w)
+	}
+
+	// Step 1b
+	switch {
+	case hasByteSuffix(w, "eedly"), hasByteSuffix(w, "eed"):
+		suffix := "eed"
+		if hasByteSuffix(w, "eedly") {
+			suffix = "eedly"
+		}
+		if len(w)-len(suffix) >= r1 {
+			w = append(w[:len(w)-len(suffix)], "ee"...)
+		}
+	case hasByteSuffix(w, "ingly"), hasByteSuffix(w, "edly"), hasByteSuffix(w, "ing"), hasByteSuffix(w, "ed"):
+		var suffix string
+		for _, s := range []string{"ingly", "edly", "ing", "ed"} {
+			if hasByteSuffix(w, s) {
+				suffix = s
+				break
+			}
+		}
+		stem := w[:len(w)-len(suffix)]
+		if !containsEnglishVowel(stem) {
+			break
+		}
+		w = stem
+		switch {
+		case hasByteSuffix(w, "at"), hasByteSuffix(w, "bl"), hasByteSuffix(w, "iz"):
+			w = append(w, 'e')
+		case endsWithEnglishDouble(w):
+			w = w[:len(w)-1]
+		case isShortEnglishWord(w, r1):
+			w = append(w, 'e')
+		}
+	}
+
+	// Step 1c
+	if n := len(w); n > 2 && (w[n-1] == 'y' || w[n-1] == 'Y') && !isEnglishVowel(w[n-2]) {
+		w[n-1] = 'i'
+	}
+
+	// Step 2
+	if s, ok := longestEnglishSuffix(w, enStep2Suffixes); ok && len(w)-len(s.suffix) >= r1 {
+		stem := w[:len(w)-len(s.suffix)]
+		switch s.suffix {
+		case "ogi":
+			if hasByteSuffix(stem, "l") {
+				w = append(stem, s.replace...)
+			}
+		case "li":
+			if len(stem) > 0 && isValidLiEnding(stem[len(stem)-1]) {
+				w = stem
+			}
+		default:
+			w = append(stem, s.replace...)
+		}
+	}
+
+	// Step 3
+	if s, ok := longestEnglishSuffix(w, enStep3Suffixes); ok && len(w)-len(s.suffix) >= r1 {
+		stem := w[:len(w)-len(s.suffix)]
+		if s.suffix != "ative" || len(stem) >= r2 {
+			w = append(stem, s.replace...)
+		}
+	}
+
+	// Step 4
+	for _, suffix := range enStep4Suffixes {
+		if !hasByteSuffix(w, suffix) {
+			continue
+		}
+		stem := w[:len(w)-len(suffix)]
+		if len(stem) >= r2 && (suffix != "ion" || hasByteSuffix(stem, "s") || hasByteSuffix(stem, "t")) {
+			w = stem
+		}
+		break
+	}
+
+	// Step 5
+	if n := len(w); n > 0 && w[n-1] == 'e' {
+		stem := w[:n-1]
+		if len(stem) >= r2 || (len(stem) >= r1 && !endsWithShortSyllable(stem)) {
+			w = stem
+		}
+	} else if n > 1 && w[n-1] == 'l' && w[n-2] == 'l' && n-1 >= r2 {
+		w = w[:n-1]
+	}
+
+	return strings.Replace(string(w), "Y", "y", -1)
+}
+
+// englishRegions returns the start of R1 and R2 regions
+func englishRegions(w []byte) (r1, r2 int) {
+	r := []rune(string(w))
+	r1 = -1
+	for _, prefix := range []string{"gener", "commun", "arsen"} {
+		if strings.HasPrefix(string(w), prefix) {
+			r1 = len(prefix)
+			break
+		}
+	}
+	if r1 < 0 {
+		r1 = regionAfter(r, 0, isEnglishVowelRune)
+	}
+	r2 = regionAfter(r, r1, isEnglishVowelRune)
+	return
+}
+
+func longestEnglishSuffix(w []byte, suffixes []enSuffix) (enSuffix, bool) {
+	var longest enSuffix
+	found := false
+	for _, s := range suffixes {
+		if len(s.suffix) > len(longest.suffix) && hasByteSuffix(w, s.suffix) {
+			longest = s
+			found = true
+		}
+	}
+	return longest, found
+}
+
+func isEnglishVowel(b byte) bool {
+	switch b {
+	case 'a', 'e', 'i', 'o', 'u', 'y':
+		return true
+	}
+	return false
+}
+
+func isEnglishVowelRune(r rune) bool {
+	return r < 128 && isEnglishVowel(byte(r))
+}
+
+func containsEnglishVowel(w []byte) bool {
+	for _, b := range w {
+		if isEnglishVowel(b) {
+			return true
+		}
+	}
+	return false
+}
+
+func endsWithEnglishDouble(w []byte) bool {
+	for _, d := range []string{"bb", "dd", "ff", "gg", "mm", "nn", "pp", "rr", "tt"} {
+		if hasByteSuffix(w, d) {
+			return true
+		}
+	}
+	return false
+}
+
+func isValidLiEnding(b byte) bool {
+	return strings.IndexByte("cdeghkmnrt", b) >= 0
+}
+
+// endsWithShortSyllable checks whether the word ends with a vowel followed by
+// a non-vowel other than w, x or Y and preceded by a non-vowel,
+// or it is a vowel at the beginning of the word followed by a non-vowel.
+func endsWithShortSyllable(w []byte) bool {
+	n := len(w)
+	if n == 2 {
+		return isEnglishVowel(w[0]) && !isEnglishVowel(w[1])
+	}
+	if n >= 3 {
+		return !isEnglishVowel(w[n-3]) && isEnglishVowel(w[n-2]) && !isEnglishVowel(w[n-1]) &&
+			w[n-1] != 'w' && w[n-1] != 'x' && w[n-1] != 'Y'
+	}
+	return false
+}
+
+func isShortEnglishWord(w []byte, r1 int) bool {
+	return r1 >= len(w) && endsWithShortSyllable(w)
+}
+
+func hasByteSuffix(w []byte, suffix string) bool {
+	return len(w) >= len(suffix) && string(w[len(w)-len(suffix):]) == suffix
+}
@@ -0,0 +1,150 @@
+package main
+
+// Russian stemmer. Implementation of the Snowball algorithm:
+// https://snowballstem.org/algorithms/russian/stemmer.html
+
+var (
+	ruPerfectiveGerund1 = []string{"в", "вши", "вшись"}
+	ruPerfectiveGerund2 = []string{"ив", "ивши", "ившись", "ыв", "ывши", "ывшись"}
+	ruAdjective         = []string{
+		"ее", "ие", "ые", "ое", "ими", "ыми", "ей", "ий", "ый", "ой", "ем", "им", "ым", "ом",
+		"его", "ого", "ему", "ому", "их", "ых", "ую", "юю", "ая", "яя", "ою", "ею",
+	}
+	ruParticiple1 = []string{"ем", "нн", "вш", "ющ", "щ"}
+	ruParticiple2 = []string{"ивш", "ывш", "ующ"}
+	ruReflexive   = []string{"ся", "сь"}
+	ruVerb1       = []string{
+		"ла", "на", "ете", "йте", "ли", "й", "л", "ем", "н", "ло", "но", "ет", "ют", "ны", "ть", "ешь", "нно",
+	}
+	ruVerb2 = []string{
+		"ила", "ыла", "ена", "ейте", "уйте", "ите", "или", "ыли", "ей", "уй", "ил", "ыл", "им", "ым", "ен",
+		"ило", "ыло", "ено", "ят", "ует", "уют", "ит", "ыт", "ены", "ить", "ыть", "ишь", "ую", "ю",
+	}
+	ruNoun = []string{
+		"а", "ев", "ов", "ие", "ье", "е", "иями", "ями", "ами", "еи", "ии", "и", "ией", "ей", "ой", "ий", "й",
+		"иям", "ям", "ием", "ем", "ам", "ом", "о", "у", "ах", "иях", "ях", "ы", "ь", "ию", "ью", "ю", "ия", "ья", "я",
+	}
+	ruSuperlative  = []string{"ейш", "ейше"}
+	ruDerivational = []string{"ост", "ость"}
+)
+
+func stemRussian(word string) string {
+	w := []rune(word)
+	rv, r2 := russianRegions(w)
+
+	// Step 1
+	if n := russianEnding(w, rv, ruPerfectiveGerund1, ruPerfectiveGerund2); n > 0 {
+		w = w[:len(w)-n]
+	} else {
+		if n := russianEnding(w, rv, nil, ruReflexive); n > 0 {
+			w = w[:len(w)-n]
+		}
+		if n := russianEnding(w, rv, nil, ruAdjective); n > 0 {
+			// Adjectival ending is an adjective with an optional participle before it
+			w = w[:len(w)-n]
+			if n := russianEnding(w, rv, ruParticiple1, ruParticiple2); n > 0 {
+				w = w[:len(w)-n]
+			}
+		} else if n := russianEnding(w, rv, ruVerb1, ruVerb2); n > 0 {
+			w = w[:len(w)-n]
+		} else if n := russianEnding(w, rv, nil, ruNoun); n > 0 {
+			w = w[:len(w)-n]
+		}
+	}
+
+	// Step 2
+	if n := russianEnding(w, rv, nil, []string{"и"}); n > 0 {
+		w = w[:len(w)-n]
+	}
+
+	// Step 3
+	if n := russianEnding(w, r2, nil, ruDerivational); n > 0 {
+		w = w[:len(w)-n]
+	}
+
+	// Step 4
+	if n := russianEnding(w, rv, nil, []string{"нн"}); n > 0 {
+		w = w[:len(w)-1]
+	} else if n := russianEnding(w, rv, nil, ruSuperlative); n > 0 {
+		w = w[:len(w)-n]
+		if n := russianEnding(w, rv, nil, []string{"нн"}); n > 0 {
+			w = w[:len(w)-1]
+		}
+	} else if n := russianEnding(w, rv, nil, []string{"ь"}); n > 0 {
+		w = w[:len(w)-n]
+	}
+
+	return string(w)
+}
+
+// russianEnding returns length of the longest ending that lies after limit.
+// Endings of the first group must be preceded by "а" or "я" which are kept.
+func russianEnding(w []rune, limit int, group1, group2 []string) int {
+	longest := 0
+	for _, ending := range group1 {
+		n := runeLength(ending)
+		if n > longest && hasRuneSuffix(w, ending) && len(w)-n-1 >= limit {
+			if prev := w[len(w)-n-1]; prev == 'а' || prev == 'я' {
+				longest = n
+			}
+		}
+	}
+	for _, ending := range group2 {
+		n := runeLength(ending)
+		if n > longest && hasRuneSuffix(w, ending) && len(w)-n >= limit {
+			longest = n
+		}
+	}
+	return longest
+}
+
+// russianRegions returns the start of RV and R2 regions
+func russianRegions(w []rune) (rv, r2 int) {
+	rv = len(w)
+	for i, r := range w {
+		if isRussianVowel(r) {
+			rv = i + 1
+			break
+		}
+	}
+	r1 := regionAfter(w, 0, isRussianVowel)
+	r2 = regionAfter(w, r1, isRussianVowel)
+	return
+}
+
+func isRussianVowel(r rune) bool {
+	switch r {
+	case 'а', 'е', 'и', 'о', 'у', 'ы', 'э', 'ю', 'я':
+		return true
+	}
+	return false
+}
+
+// regionAfter returns the position after the first non-vowel following a vowel
+// starting from the position start, or the word length if there is no such one
+func regionAfter(w []rune, start int, isVowel func(rune) bool) int {
+	for i := start + 1; i < len(w); i++ {
+		if !isVowel(w[i]) && isVowel(w[i-1]) {
+			return i + 1
+		}
+	}
+	return len(w)
+}
+
+func hasRuneSuffix(w []rune, suffix string) bool {
+	s := []rune(suffix)
+	if len(s) > len(w) {
+		return false
+	}
+	offset := len(w) - len(s)
+	for i := range s {
+		if w[offset+i] != s[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func runeLength(s string) int {
+	return len([]rune(s))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Bundled stop words by language. The languages used by a project are set in its config.
+var defaultStopWords = map[string][]string{
+	"ru": {
+		"а", "без", "безо", "бы", "в", "во", "вот", "все", "всё", "вы", "где", "да", "для", "до", "если",
+		"есть", "же", "за", "и", "из", "изо", "или", "как", "какой", "когда", "кто", "ли", "либо",
+		"между", "мне", "мой", "на", "над", "надо", "не", "нет", "ни", "но", "о", "об", "обо", "он",
+		"она", "они", "от", "ото", "по", "под", "подо", "после", "почему", "при", "про", "с", "со",
+		"так", "также", "то", "тоже", "у", "через", "что", "чтобы", "чем", "это", "этот", "я",
+	},
+	"en": {
+		"a", "about", "after", "all", "an", "and", "any", "are", "as", "at", "be", "by", "can", "do",
+		"does", "for", "from", "how", "i", "if", "in", "into", "is", "it", "its", "me", "my", "near",
+		"no", "not", "of", "on", "or", "our", "out", "over", "so", "than", "that", "the", "their",
+		"them", "there", "these", "this", "to", "up", "vs", "was", "what", "when", "where", "which",
+		"who", "why", "will", "with", "without", "you", "your",
+	},
+}
+
+// Languages of the bundled stop words which are used by new projects
+var defaultStopWordLanguages = []string{"ru", "en"}
+
+// stopWordLanguages returns the names of the bundled lists
+func stopWordLanguages() []string {
+	languages := make([]string, 0, len(defaultStopWords))
+	for language := range defaultStopWords {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// wordForms returns the word as it's written and normalized, so it matches
+// both the lemmas of the source files and the normalized keywords
+func wordForms(word string, normalizer Normalizer) []string {
+	word = strings.ToLower(strings.TrimSpace(word))
+	if word == "" {
+		return nil
+	}
+	forms := []string{word}
+	if normalizer != nil {
+		for _, lemma := range strings.Fields(normalizer.Normalize(word)) {
+			if lemma != word {
+				forms = append(forms, lemma)
+			}
+		}
+	}
+	return forms
+}
+
+// stopWordSet collects the bundled and the project stop words in all their forms
+func stopWordSet(config *ProjectConfig, normalizer Normalizer) map[string]struct{} {
+	set := make(map[string]struct{})
+	add := func(word string) {
+		for _, form := range wordForms(word, normalizer) {
+			set[form] = struct{}{}
+		}
+	}
+	for _, language := range config.StopWordLanguages {
+		for _, word := range defaultStopWords[language] {
+			add(word)
+		}
+	}
+	for _, word := range config.StopWords {
+		add(word)
+	}
+	return set
+}
+
+// UpdateStopWords passes the stop words of the config to the index of the project
+func (p *Project) UpdateStopWords() {
+	p.Index.SetStopWords(stopWordSet(p.Config, p.Normalizer))
+}
+
+// AddStopWord adds the word into the project stop words and saves the config.
+// It returns false if the word is already a stop word.
+func (p *Project) AddStopWord(word string) bool {
+	// Stop words are compared in lower case, see wordForms
+	word = strings.ToLower(strings.TrimSpace(word))
+	if word == "" || p.Index.IsStopWord(word) {
+		return false
+	}
+	p.Config.StopWords = append(p.Config.StopWords, word)
+	p.UpdateStopWords()
+	p.SaveConfig()
+	return true
+}
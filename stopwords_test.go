@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// subClusterSizes returns the sorted names of the subclusters of the rows with their sizes
+func subClusterSizes(rows []*Row, index *WordIndex) string {
+	root := NewCluster("", rows, nil)
+	root.Index = index
+	var sizes []string
+	for name, cluster := range root.GenerateSubClusters(nil, 1) {
+		sizes = append(sizes, fmt.Sprintf("%s:%d", name, len(cluster.Rows)))
+	}
+	sort.Strings(sizes)
+	return strings.Join(sizes, " ")
+}
+
+func TestStopWordsInSubClusters(t *testing.T) {
+	rows := queryRowsOf(
+		"купить матрас в москве",
+		"матрас для дачи",
+		"купить подушку",
+		"the best pillow",
+	)
+	tests := []struct {
+		languages []string
+		words     []string
+		want      string
+	}{
+		{nil, nil, "best:1 pillow:1 the:1 в:1 дач:1 для:1 куп:2 матрас:2 москв:1 подушк:1"},
+		{[]string{"ru"}, nil, "best:1 pillow:1 the:1 дач:1 куп:2 матрас:2 москв:1 подушк:1"},
+		{[]string{"ru", "en"}, nil, "best:1 pillow:1 дач:1 куп:2 матрас:2 москв:1 подушк:1"},
+		// Project stop words match the keywords in all their forms
+		{[]string{"ru", "en"}, []string{"Купить", "москва"}, "best:1 pillow:1 дач:1 матрас:2 подушк:1"},
+	}
+	for _, test := range tests {
+		index := NewWordIndex(rows)
+		config := &ProjectConfig{StopWordLanguages: test.languages, StopWords: test.words}
+		index.SetStopWords(stopWordSet(config, StemNormalizer{}))
+		if got := subClusterSizes(rows, index); got != test.want {
+			t.Errorf("%v %v: subclusters %q, want %q", test.languages, test.words, got, test.want)
+		}
+	}
+}
+
+func TestAddStopWord(t *testing.T) {
+	p := newTestProject(t, "buy shoes", "cheap shoes", "the boots")
+	defer closeTestProject(t, p)
+	tests := []struct {
+		word  string
+		added bool
+	}{
+		{"the", false},
+		{"cheap", true},
+		{"Cheap", false},
+		{"shoes", true},
+		{"  ", false},
+	}
+	for _, test := range tests {
+		if added := p.AddStopWord(test.word); added != test.added {
+			t.Errorf("%s: added %v, want %v", test.word, added, test.added)
+		}
+	}
+	if got, want := subClusterSizes(p.Rows, p.Index), "boot:1 buy:1"; got != want {
+		t.Errorf("subclusters %q, want %q", got, want)
+	}
+
+	// The stop words are saved in the config
+	if err := p.flush(); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadProjectConfig(p.Paths.ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if words := strings.Join(config.StopWords, " "); words != "cheap shoes" {
+		t.Errorf("saved stop words %q", words)
+	}
+}
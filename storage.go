@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+)
+
+// Lock file of the project directory, it's held while the project is opened
+const ProjectLockFile = ".lock"
+
+var errProjectLocked = errors.New("project is opened by another instance")
+
+// projectWriter writes the project files one by one in a single goroutine,
+// so an older state is never written over a newer one.
+// The queue has no limit, so the UI never waits for the disk.
+type projectWriter struct {
+	pending sync.WaitGroup
+
+	mutex sync.Mutex
+	// Signaled when a task is queued or the writer is closed
+	ready  *sync.Cond
+	tasks  []func() error
+	closed bool
+	// The first error since the last Wait
+	err     error
+	onError func(err error)
+}
+
+func newProjectWriter() *projectWriter {
+	w := &projectWriter{}
+	w.ready = sync.NewCond(&w.mutex)
+	go w.run()
+	return w
+}
+
+// run does the queued tasks until the writer is closed
+func (w *projectWriter) run() {
+	for {
+		w.mutex.Lock()
+		for len(w.tasks) == 0 && !w.closed {
+			w.ready.Wait()
+		}
+		if len(w.tasks) == 0 {
+			w.mutex.Unlock()
+			return
+		}
+		task := w.tasks[0]
+		w.tasks[0] = nil
+		w.tasks = w.tasks[1:]
+		w.mutex.Unlock()
+
+		if err := runTask(task); err != nil {
+			w.fail(err)
+		}
+		w.pending.Done()
+	}
+}
+
+// runTask returns a panic of the task as its error, so the writer keeps writing the next tasks
+func runTask(task func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("unexpected error: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return task()
+}
+
+func (w *projectWriter) fail(err error) {
+	w.mutex.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	onError := w.onError
+	w.mutex.Unlock()
+	if onError != nil {
+		onError(err)
+	}
+}
+
+// SetErrorFunc sets the function which is called in the writer goroutine on every failed task
+func (w *projectWriter) SetErrorFunc(onError func(err error)) {
+	w.mutex.Lock()
+	w.onError = onError
+	w.mutex.Unlock()
+}
+
+// Do queues the task, it never blocks. The data of the task must not be changed after it's queued.
+func (w *projectWriter) Do(task func() error) {
+	w.pending.Add(1)
+	w.mutex.Lock()
+	w.tasks = append(w.tasks, task)
+	w.mutex.Unlock()
+	w.ready.Signal()
+}
+
+// Wait blocks until all queued tasks are done and returns the first error since the previous call
+func (w *projectWriter) Wait() error {
+	w.pending.Wait()
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	err := w.err
+	w.err = nil
+	return err
+}
+
+// Close stops the goroutine of the writer when the queued tasks are done.
+// Tasks must not be queued after it.
+func (w *projectWriter) Close() {
+	w.mutex.Lock()
+	w.closed = true
+	w.mutex.Unlock()
+	w.ready.Broadcast()
+}
+
+// writeFileAtomic writes the file into a temporary one in the same directory and renames it,
+// so a crash never leaves the file partly written
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	temp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	// The temporary file is left only if something fails
+	defer os.Remove(temp.Name())
+
+	writer := bufio.NewWriter(temp)
+	err = write(writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = temp.Sync()
+	}
+	if err == nil {
+		err = temp.Chmod(0644)
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// writeFileDataAtomic writes the data by writeFileAtomic
+func writeFileDataAtomic(path string, data []byte) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// lockProject takes the lock of the project directory
+func lockProject(dir string) (*os.File, error) {
+	lock, err := lockFile(filepath.Join(dir, ProjectLockFile))
+	if err == errProjectLocked {
+		return nil, fmt.Errorf("project %s is opened by another instance", dir)
+	}
+	return lock, err
+}
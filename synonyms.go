@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Modes of the cuts by a query when the project has synonyms
+const (
+	SynonymCutsGroup = "group" // words match all words of their groups
+	SynonymCutsWord  = "word"  // words match only themselves
+)
+
+// Synonyms map every word of a group to the group. The groups are set in the project config.
+type Synonyms map[string]*synonymGroup
+
+type synonymGroup struct {
+	// Name of the group in the cluster tree
+	Canonical string
+	Words     []string
+}
+
+// newSynonyms normalizes the groups of the config. The canonical word of a group is the first form
+// of its first word which is indexed, so the tree shows it as the other words of the index.
+func newSynonyms(groups [][]string, normalizer Normalizer, index *WordIndex) Synonyms {
+	if len(groups) == 0 {
+		return nil
+	}
+	synonyms := make(Synonyms)
+	for _, words := range groups {
+		group := &synonymGroup{}
+		for _, word := range words {
+			for _, form := range wordForms(word, normalizer) {
+				if !containsString(group.Words, form) {
+					group.Words = append(group.Words, form)
+				}
+			}
+		}
+		if len(group.Words) == 0 {
+			continue
+		}
+		group.Canonical = group.Words[0]
+		for _, form := range wordForms(words[0], normalizer) {
+			if _, ok := index.wordIDs[form]; ok {
+				group.Canonical = form
+				break
+			}
+		}
+		for _, word := range group.Words {
+			synonyms[word] = group
+		}
+	}
+	return synonyms
+}
+
+// Group returns the words of the group of the word, nil if the word has no synonyms
+func (s Synonyms) Group(word string) []string {
+	if group, ok := s[word]; ok {
+		return group.Words
+	}
+	return nil
+}
+
+// synonymsOfGroups returns the synonyms of the normalized groups which are saved in the history
+func synonymsOfGroups(groups [][]string) Synonyms {
+	synonyms := make(Synonyms)
+	for _, words := range groups {
+		if len(words) == 0 {
+			continue
+		}
+		group := &synonymGroup{Canonical: words[0], Words: words}
+		for _, word := range words {
+			synonyms[word] = group
+		}
+	}
+	return synonyms
+}
+
+// containsGroup checks whether the group is in the list. A word is in one group only,
+// so groups are compared by their first words.
+func containsGroup(groups [][]string, group []string) bool {
+	for _, other := range groups {
+		if len(other) > 0 && len(group) > 0 && other[0] == group[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// Canonical returns the name of the group of the word, the word itself if it has no synonyms
+func (s Synonyms) Canonical(word string) string {
+	if group, ok := s[word]; ok {
+		return group.Canonical
+	}
+	return word
+}
+
+// validateSynonyms checks that every group has several words and every word is in one group
+func validateSynonyms(groups [][]string) error {
+	groupOf := make(map[string]int)
+	for i, words := range groups {
+		if len(words) < 2 {
+			return fmt.Errorf("synonym group %d must have at least two words", i+1)
+		}
+		for _, word := range words {
+			word = strings.ToLower(strings.TrimSpace(word))
+			if other, ok := groupOf[word]; ok && other != i {
+				return fmt.Errorf("word \"%s\" is in synonym groups %d and %d", word, other+1, i+1)
+			}
+			groupOf[word] = i
+		}
+	}
+	return nil
+}
+
+// UpdateSynonyms passes the synonym groups of the config to the index of the project
+func (p *Project) UpdateSynonyms() {
+	p.Index.SetSynonyms(newSynonyms(p.Config.Synonyms, p.Normalizer, p.Index))
+}
+
+// expandSynonyms checks whether new cuts by a query cover the synonyms of its words
+func (p *Project) expandSynonyms() bool {
+	return len(p.Config.Synonyms) > 0 && p.Config.SynonymCuts == SynonymCutsGroup
+}
+
+// querySynonyms returns the synonyms of the operation, nil if its words don't match their synonyms.
+// An operation is replayed with the groups which were used when it was made, so editing
+// the groups of the config doesn't change the cut rows. Operations of older history files have
+// no saved groups, they are replayed with the groups of the index.
+func (op *KeywordOperation) querySynonyms(index *WordIndex) Synonyms {
+	if !op.Synonyms {
+		return nil
+	}
+	if op.SynonymGroups == nil {
+		return index.Synonyms()
+	}
+	return synonymsOfGroups(op.SynonymGroups)
+}
+
+// querySynonyms returns the synonyms which are matched by new cuts, nil if they are not expanded
+func (p *Project) querySynonyms(expand bool) Synonyms {
+	if !expand {
+		return nil
+	}
+	return p.Index.Synonyms()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSynonymsInSubClusters(t *testing.T) {
+	rows := queryRowsOf(
+		"купить матрас",
+		"заказать матрас",
+		"матрас цена",
+		"приобрести подушку",
+		"цена подушки",
+	)
+	tests := []struct {
+		groups [][]string
+		want   string
+	}{
+		{nil, "заказа:1 куп:1 матрас:3 подушк:2 приобрест:1 цен:2"},
+		{[][]string{{"купить", "заказать"}}, "куп:2 матрас:3 подушк:2 приобрест:1 цен:2"},
+		{[][]string{{"заказать", "купить", "приобрести"}}, "заказа:3 матрас:3 подушк:2 цен:2"},
+		{[][]string{{"купить", "заказать"}, {"цена", "подушка"}}, "куп:2 матрас:3 приобрест:1 цен:3"},
+		// The group is named by its first word even if no keyword has it
+		{[][]string{{"оплатить", "купить"}}, "заказа:1 матрас:3 оплатить:1 подушк:2 приобрест:1 цен:2"},
+	}
+	for _, test := range tests {
+		index := NewWordIndex(rows)
+		index.SetSynonyms(newSynonyms(test.groups, StemNormalizer{}, index))
+		if got := subClusterSizes(rows, index); got != test.want {
+			t.Errorf("%v: subclusters %q, want %q", test.groups, got, test.want)
+		}
+	}
+}
+
+func TestSynonymsInChildren(t *testing.T) {
+	rows := queryRowsOf(
+		"купить матрас",
+		"заказать матрас недорого",
+		"купить подушку",
+	)
+	index := NewWordIndex(rows)
+	index.SetSynonyms(newSynonyms([][]string{{"купить", "заказать"}}, StemNormalizer{}, index))
+	root := NewCluster("", rows, nil)
+	root.Index = index
+	parent := root.GenerateSubClusters(nil, 1)["куп"]
+	if parent == nil {
+		t.Fatal("no subcluster of the group")
+	}
+	// Children of the group don't repeat any of its words
+	var children []string
+	for name, cluster := range parent.GenerateSubClusters(nil, 1) {
+		children = append(children, fmt.Sprintf("%s:%d", name, len(cluster.Rows)))
+	}
+	sort.Strings(children)
+	if got, want := strings.Join(children, " "), "матрас:2 недор:1 подушк:1"; got != want {
+		t.Errorf("children %q, want %q", got, want)
+	}
+}
+
+func TestValidateSynonyms(t *testing.T) {
+	tests := []struct {
+		groups [][]string
+		valid  bool
+	}{
+		{nil, true},
+		{[][]string{{"купить", "заказать"}, {"цена", "стоимость"}}, true},
+		{[][]string{{"купить"}}, false},
+		{[][]string{{"купить", "заказать"}, {"Купить ", "приобрести"}}, false},
+		{[][]string{{"купить", "купить"}}, true},
+	}
+	for _, test := range tests {
+		if err := validateSynonyms(test.groups); (err == nil) != test.valid {
+			t.Errorf("%v: error %v, want valid %v", test.groups, err, test.valid)
+		}
+	}
+}
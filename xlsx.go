@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"github.com/xuri/excelize/v2"
+	"gopkg.in/cheggaaa/pb.v2"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	ClusterFormatCSV  = "csv"
+	ClusterFormatXLSX = "xlsx"
+)
+
+// Excel limits the sheet name length
+const maxSheetNameLength = 31
+
+// Headers of the saved rows in the same order as csv files have
+var rowHeaders = []interface{}{ColumnLemma, ColumnKeyword, ColumnFrequency, ColumnStrongFrequency}
+
+func isXLSXFile(file string) bool {
+	return strings.ToLower(filepath.Ext(file)) == ".xlsx"
+}
+
+// xlsxRecordReader reads records of a workbook sheet.
+// Empty rows are skipped and short rows are padded to the header width.
+type xlsxRecordReader struct {
+	workbook *excelize.File
+	rows     *excelize.Rows
+	line     int
+	width    int
+	bar      *pb.ProgressBar
+}
+
+func openXLSXRecords(file string, options SourceOptions, progress bool) (*xlsxRecordReader, error) {
+	workbook, err := excelize.OpenFile(file)
+	if err != nil {
+		return nil, err
+	}
+	sheet, err := findSheet(workbook, options.Sheet)
+	if err != nil {
+		workbook.Close()
+		return nil, err
+	}
+	rows, err := workbook.Rows(sheet)
+	if err != nil {
+		workbook.Close()
+		return nil, err
+	}
+
+	// The row count is unknown until the sheet is read, so only the read rows are counted
+	bar := pb.New(0)
+	bar.SetTemplateString(`{{counters . }} rows {{etime . }}`)
+	if progress {
+		bar.Start()
+	}
+	return &xlsxRecordReader{workbook: workbook, rows: rows, bar: bar}, nil
+}
+
+func (r *xlsxRecordReader) Read() ([]string, error) {
+	for r.rows.Next() {
+		r.line++
+		r.bar.Increment()
+		record, err := r.rows.Columns()
+		if err != nil {
+			return nil, err
+		}
+		// Trailing empty cells are not meaningful
+		for len(record) > r.width && len(record) > 0 && strings.TrimSpace(record[len(record)-1]) == "" {
+			record = record[:len(record)-1]
+		}
+		if len(record) == 0 {
+			continue
+		}
+		if r.width == 0 {
+			// The first non-empty row is the header
+			r.width = len(record)
+		}
+		for len(record) < r.width {
+			record = append(record, "")
+		}
+		return record, nil
+	}
+	if err := r.rows.Error(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (r *xlsxRecordReader) Line() int {
+	return r.line
+}
+
+// Close releases the temporary files of the workbook
+func (r *xlsxRecordReader) Close() error {
+	r.bar.Finish()
+	err := r.rows.Close()
+	if closeErr := r.workbook.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// findSheet returns the sheet by its name or 1-based number, or the first sheet
+func findSheet(workbook *excelize.File, sheet string) (string, error) {
+	sheets := workbook.GetSheetList()
+	if len(sheets) == 0 {
+		return "", fmt.Errorf("workbook has no sheets")
+	}
+	if sheet == "" {
+		return sheets[0], nil
+	}
+	for _, name := range sheets {
+		if strings.EqualFold(name, sheet) {
+			return name, nil
+		}
+	}
+	if n, err := strconv.Atoi(sheet); err == nil && n >= 1 && n <= len(sheets) {
+		return sheets[n-1], nil
+	}
+	return "", fmt.Errorf("sheet \"%s\" is not found, available: %s", sheet, strings.Join(sheets, ", "))
+}
+
+// saveRowsXLSX writes the rows into a workbook with the single sheet
+func saveRowsXLSX(rows []*Row, path string) error {
+	workbook := excelize.NewFile()
+	defer workbook.Close()
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	sheet := sheetName(name, map[string]struct{}{})
+	workbook.SetSheetName(workbook.GetSheetName(0), sheet)
+	if err := writeRowsSheet(workbook, sheet, rows); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, workbook.Write)
+}
+
+func writeRowsSheet(workbook *excelize.File, sheet string, rows []*Row) error {
+	writer, err := workbook.NewStreamWriter(sheet)
+	if err != nil {
+		return err
+	}
+	if err := writer.SetRow("A1", rowHeaders); err != nil {
+		return err
+	}
+	for i, row := range rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+2)
+		values := []interface{}{row.NormalizedKeyword, row.Keyword, row.Frequency, row.StrongFrequency}
+		if err := writer.SetRow(cell, values); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// ExportWorkbook writes the remaining rows and all cluster files of the project
+// into one workbook with a sheet per cluster. Sheets of removed clusters are prefixed with "-",
+// sheets of clusters in categories are prefixed with the category path.
+func (p *Project) ExportWorkbook(path string) error {
+	// Cluster files are read, so the queued writes are finished first
+	if err := p.flush(); err != nil {
+		return err
+	}
+	workbook := excelize.NewFile()
+	defer workbook.Close()
+	used := make(map[string]struct{})
+
+	remains := sheetName("Остаток", used)
+	workbook.SetSheetName(workbook.GetSheetName(0), remains)
+	if err := writeRowsSheet(workbook, remains, p.Rows); err != nil {
+		return err
+	}
+
+	dirs := []struct {
+		path   string
+		prefix string
+	}{
+		{p.Paths.ClustersDir, ""},
+		{p.Paths.RemovedDir, "-"},
+	}
+	for _, dir := range dirs {
+		err := filepath.Walk(dir.path, func(file string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				// Removed clusters are exported with their own prefix
+				if file != dir.path && file == p.Paths.RemovedDir {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			ext := strings.ToLower(filepath.Ext(file))
+			if ext != ".csv" && ext != ".xlsx" {
+				return nil
+			}
+			rel, err := filepath.Rel(dir.path, file)
+			if err != nil {
+				return err
+			}
+			rows, err := ReadRows(file)
+			if err != nil {
+				return fmt.Errorf("%s: %v", rel, err)
+			}
+			// Clusters of categories are named by the category path
+			name := strings.TrimSuffix(filepath.ToSlash(rel), filepath.Ext(rel))
+			sheet := sheetName(dir.prefix+strings.Replace(name, "/", " - ", -1), used)
+			workbook.NewSheet(sheet)
+			return writeRowsSheet(workbook, sheet, rows)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeFileAtomic(path, workbook.Write)
+}
+
+// sheetName makes a valid unique sheet name
+func sheetName(name string, used map[string]struct{}) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, "'")
+	if name == "" {
+		name = "_"
+	}
+
+	candidate := truncateRunes(name, maxSheetNameLength)
+	for i := 2; ; i++ {
+		if _, ok := used[strings.ToLower(candidate)]; !ok {
+			break
+		}
+		suffix := fmt.Sprintf(" (%d)", i)
+		candidate = truncateRunes(name, maxSheetNameLength-utf8.RuneCountInString(suffix)) + suffix
+	}
+	used[strings.ToLower(candidate)] = struct{}{}
+	return candidate
+}
+
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}